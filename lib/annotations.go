@@ -0,0 +1,143 @@
+package e2e
+
+import (
+	"fmt"
+	"go/ast"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// annotationPrefix starts a go-e2e annotation comment directly above a test
+// function, e.g.
+//
+//	//go:e2e timeout: 5m
+//	func TestSlow(t *testing.T) { ... }
+//
+// Each annotation is a "key: value" pair on its own line.
+const annotationPrefix = "//go:e2e "
+
+// parseAnnotations returns the go-e2e annotations in a test function's doc
+// comment, keyed by name.
+func parseAnnotations(doc *ast.CommentGroup) (map[string]string, error) {
+	if doc == nil {
+		return nil, nil
+	}
+	var annotations map[string]string
+	for _, c := range doc.List {
+		text, ok := strings.CutPrefix(c.Text, annotationPrefix)
+		if !ok {
+			continue
+		}
+		key, value, ok := strings.Cut(text, ":")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid annotation %q: expected %skey: value", c.Text, annotationPrefix)
+		}
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[key] = value
+	}
+	return annotations, nil
+}
+
+// applyAnnotations records the settings of a test function's annotations:
+//
+//	timeout: <duration>      overrides TestTimeout
+//	healthcheck: <url>       must respond with a 2xx status for the test to pass
+//	weight: <n>              parallelism slots the test takes while it runs
+//	requires: <deps>         wait-for entries or container names the test needs
+//	skip-unless-env: <vars>  host environment variables the test needs to run
+func (r *Runner) applyAnnotations(test string, doc *ast.CommentGroup) error {
+	annotations, err := parseAnnotations(doc)
+	if err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(annotations) {
+		value := annotations[key]
+		switch key {
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("invalid timeout annotation %q: expected a positive duration", value)
+			}
+			if r.testTimeouts == nil {
+				r.testTimeouts = make(map[string]time.Duration)
+			}
+			r.testTimeouts[test] = timeout
+		case "healthcheck":
+			if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid healthcheck annotation %q: expected an http or https URL", value)
+			}
+			if r.testHealthChecks == nil {
+				r.testHealthChecks = make(map[string]string)
+			}
+			r.testHealthChecks[test] = value
+		case "weight":
+			weight, err := strconv.Atoi(value)
+			if err != nil || weight <= 0 {
+				return fmt.Errorf("invalid weight annotation %q: expected a positive integer", value)
+			}
+			if r.testWeights == nil {
+				r.testWeights = make(map[string]int)
+			}
+			r.testWeights[test] = weight
+		case "requires":
+			var deps []string
+			for _, dep := range strings.Split(value, ",") {
+				dep = strings.TrimSpace(dep)
+				if dep != "" && !strings.Contains(dep, ":") {
+					dep = waitForContainer + dep
+				}
+				deps = append(deps, dep)
+			}
+			if err := validateWaitFor(deps); err != nil {
+				return fmt.Errorf("invalid requires annotation %q: %v", value, err)
+			}
+			if r.testRequires == nil {
+				r.testRequires = make(map[string][]string)
+			}
+			r.testRequires[test] = deps
+		case "skip-unless-env":
+			var vars []string
+			for _, name := range strings.Split(value, ",") {
+				name = strings.TrimSpace(name)
+				if name == "" || strings.ContainsAny(name, "= \t") {
+					return fmt.Errorf("invalid skip-unless-env annotation %q: expected comma-separated environment variable names", value)
+				}
+				vars = append(vars, name)
+			}
+			if r.testRequiredEnv == nil {
+				r.testRequiredEnv = make(map[string][]string)
+			}
+			r.testRequiredEnv[test] = vars
+		default:
+			return fmt.Errorf("unknown annotation %q", key)
+		}
+	}
+	return nil
+}
+
+// testTimeout returns the timeout for a test: its timeout annotation if it
+// has one, otherwise TestTimeout.
+func (r *Runner) testTimeout(test string) time.Duration {
+	if timeout, ok := r.testTimeouts[r.testName(test)]; ok {
+		return timeout
+	}
+	return r.config.TestTimeout
+}
+
+// missingEnv returns the environment variables named by a test's
+// skip-unless-env annotation that are not set on the host.
+func (r *Runner) missingEnv(test string) []string {
+	var missing []string
+	for _, name := range r.testRequiredEnv[r.testName(test)] {
+		if os.Getenv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,48 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// testdataDir is the directory in TestDir that go test reads fixtures from
+// relative to the package directory.
+const testdataDir = "testdata"
+
+// validateAssetsMountPath checks that the assets mount path, if set, is an
+// absolute container path.
+func validateAssetsMountPath(mountPath string) error {
+	if mountPath != "" && !path.IsAbs(mountPath) {
+		return fmt.Errorf("invalid assets mount path %q: must be an absolute path in the container", mountPath)
+	}
+	return nil
+}
+
+// assetsArgs returns the docker run arguments that make the test binary run
+// in AssetsMountPath, with TestDir's testdata directory, if any, mounted
+// read-only under it, so tests find fixtures at ./testdata as with go test.
+func (r *Runner) assetsArgs() []string {
+	if r.config.AssetsMountPath == "" {
+		return nil
+	}
+	args := []string{"--workdir", r.config.AssetsMountPath}
+	if dir := r.testdataDir(); dir != "" {
+		args = append(args, "-v", dir+":"+path.Join(r.config.AssetsMountPath, testdataDir)+":ro")
+	}
+	return args
+}
+
+// testdataDir returns the absolute path of TestDir's testdata directory, or
+// "" if there is none.
+func (r *Runner) testdataDir() string {
+	dir, err := filepath.Abs(filepath.Join(r.config.TestDir, testdataDir))
+	if err != nil {
+		return ""
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return ""
+	}
+	return dir
+}
@@ -0,0 +1,152 @@
+package e2e
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// platformTags are set by the build platform rather than by build tags, so
+// they are never enabled as tags.
+var platformTags = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true,
+	"freebsd": true, "illumos": true, "ios": true, "js": true, "linux": true,
+	"netbsd": true, "openbsd": true, "plan9": true, "solaris": true,
+	"wasip1": true, "windows": true, "unix": true,
+	"386": true, "amd64": true, "arm": true, "arm64": true, "loong64": true,
+	"mips": true, "mips64": true, "mips64le": true, "mipsle": true,
+	"ppc64": true, "ppc64le": true, "riscv64": true, "s390x": true,
+	"wasm": true, "cgo": true, "gc": true, "gccgo": true,
+}
+
+// implicitTags returns the tags satisfied by the test image's build
+// environment without being passed as build tags: linux, the build
+// architecture, and cgo when it is enabled.
+func (r *Runner) implicitTags() map[string]bool {
+	tags := map[string]bool{
+		"linux":    true,
+		"unix":     true,
+		"gc":       true,
+		r.goarch(): true,
+	}
+	if r.cgoEnabled() {
+		tags["cgo"] = true
+	}
+	return tags
+}
+
+// isImplicitTag reports whether a tag is satisfied by the build environment
+// rather than by a build tag.
+func isImplicitTag(tag string, implicit map[string]bool) bool {
+	return implicit[tag] || strings.HasPrefix(tag, "go1.")
+}
+
+// fileBuildConstraint returns the //go:build constraint of a parsed file, or
+// nil if it has none.
+func fileBuildConstraint(f *ast.File) (constraint.Expr, error) {
+	for _, group := range f.Comments {
+		if group.Pos() > f.Package {
+			break
+		}
+		for _, c := range group.List {
+			if constraint.IsGoBuild(c.Text) {
+				return constraint.Parse(c.Text)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// positiveTags adds the tags referenced by expr without negation to tags,
+// except for platform and release tags.
+func positiveTags(expr constraint.Expr, negated bool, tags map[string]bool) {
+	switch e := expr.(type) {
+	case *constraint.TagExpr:
+		if !negated && !platformTags[e.Tag] && !strings.HasPrefix(e.Tag, "go1.") {
+			tags[e.Tag] = true
+		}
+	case *constraint.NotExpr:
+		positiveTags(e.X, !negated, tags)
+	case *constraint.AndExpr:
+		positiveTags(e.X, negated, tags)
+		positiveTags(e.Y, negated, tags)
+	case *constraint.OrExpr:
+		positiveTags(e.X, negated, tags)
+		positiveTags(e.Y, negated, tags)
+	}
+}
+
+// satisfiesBuildTags reports whether expr is satisfied with the given tags
+// enabled in the test image's build environment.
+func satisfiesBuildTags(expr constraint.Expr, implicit, tags map[string]bool) bool {
+	return expr.Eval(func(tag string) bool {
+		return tags[tag] || isImplicitTag(tag, implicit)
+	})
+}
+
+// buildTagSet returns the resolved build tags as a set.
+func (r *Runner) buildTagSet() map[string]bool {
+	tags := make(map[string]bool, len(r.buildTags))
+	for _, tag := range r.buildTags {
+		tags[tag] = true
+	}
+	return tags
+}
+
+// resolveBuildTags determines the build tags used for the build and for test
+// discovery: the configured BuildTags plus, with AutoBuildTags, every tag that
+// the test files' //go:build lines reference without negation, except
+// platform tags. Tags that only appear negated are never enabled, and all
+// tags in an OR expression are.
+func (r *Runner) resolveBuildTags() error {
+	tags := make(map[string]bool)
+	for _, tag := range r.config.BuildTags {
+		tags[tag] = true
+	}
+
+	if r.config.AutoBuildTags {
+		fset := token.NewFileSet()
+		err := filepath.Walk(r.config.TestDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !strings.HasSuffix(path, "_test.go") {
+				return nil
+			}
+			f, err := parser.ParseFile(fset, path, nil, parser.ParseComments|parser.PackageClauseOnly)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %v", path, err)
+			}
+			expr, err := fileBuildConstraint(f)
+			if err != nil {
+				return fmt.Errorf("invalid build constraint in %s: %v", path, err)
+			}
+			if expr != nil {
+				positiveTags(expr, false, tags)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scan build tags: %v", err)
+		}
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+	r.buildTags = make([]string, 0, len(tags))
+	for tag := range tags {
+		r.buildTags = append(r.buildTags, tag)
+	}
+	sort.Strings(r.buildTags)
+	if r.config.Verbosity > 0 {
+		fmt.Printf("--- INFO: Using build tags: %s\n", strings.Join(r.buildTags, ","))
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package e2e
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "<redacted>"
+
+// bundleRecord is what is kept of a test run for the bundle.
+type bundleRecord struct {
+	command string
+	output  string
+	stdout  string
+	stderr  string
+}
+
+// bundleFile is a file in a bundle.
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+// bundleSummary is the summary.json file in a bundle.
+type bundleSummary struct {
+	Passed     []string           `json:"passed"`
+	Failed     []string           `json:"failed"`
+	Incomplete []string           `json:"incomplete"`
+	Skipped    []string           `json:"skipped"`
+	Duration   float64            `json:"duration"`
+	Timings    map[string]float64 `json:"timings"`
+}
+
+// recordForBundle keeps a test's command line and output for the bundle, if
+// BundlePath is set.
+func (r *Runner) recordForBundle(test string, args []string, output *testOutput) {
+	if r.config.BundlePath == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.bundleRecords == nil {
+		r.bundleRecords = make(map[string]bundleRecord)
+	}
+	r.bundleRecords[test] = bundleRecord{
+		command: "docker " + strings.Join(redactArgs(args), " "),
+		output:  output.String(),
+		stdout:  output.stdout.String(),
+		stderr:  output.stderr.String(),
+	}
+}
+
+// writeBundle writes the config, test command lines, test output and summary
+// of the run to a .tar.gz archive at path. The config is redacted as by
+// ConfigYAML.
+func (r *Runner) writeBundle(path string, suiteDuration time.Duration) error {
+	configData, err := r.ConfigYAML()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	summary := bundleSummary{
+		Passed:     append([]string{}, r.passedTests...),
+		Failed:     append([]string{}, r.failedTests...),
+		Incomplete: append([]string{}, r.incompleteTests...),
+		Skipped:    append(append(append(append([]string{}, r.skippedTests...), r.selfSkippedTests...), r.budgetSkippedTests...), r.envSkippedTests...),
+		Duration:   suiteDuration.Seconds(),
+		Timings:    make(map[string]float64, len(r.testTimings)),
+	}
+	for test, d := range r.testTimings {
+		summary.Timings[test] = d.Seconds()
+	}
+	tests := make([]string, 0, len(r.bundleRecords))
+	for test := range r.bundleRecords {
+		tests = append(tests, test)
+	}
+	sort.Strings(tests)
+	var commands strings.Builder
+	logs := make(map[string]bundleRecord, len(tests))
+	for _, test := range tests {
+		rec := r.bundleRecords[test]
+		fmt.Fprintf(&commands, "%s: %s\n", test, rec.command)
+		logs[test] = rec
+	}
+	r.mu.Unlock()
+
+	summaryData, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary: %v", err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	files := []bundleFile{
+		{"config.yaml", configData},
+		{"summary.json", append(summaryData, '\n')},
+		{"commands.txt", []byte(commands.String())},
+	}
+	for _, test := range tests {
+		name := "logs/" + logFileName(test)
+		base := strings.TrimSuffix(name, ".log")
+		files = append(files,
+			bundleFile{name, []byte(logs[test].output)},
+			bundleFile{base + ".stdout.log", []byte(logs[test].stdout)},
+			bundleFile{base + ".stderr.log", []byte(logs[test].stderr)})
+	}
+	now := time.Now()
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write bundle: %v", err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return fmt.Errorf("failed to write bundle: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write bundle: %v", err)
+	}
+	fmt.Printf("--- INFO: Wrote run bundle to %s\n", path)
+	return nil
+}
+
+// logFileNamePattern matches characters not kept in log file names.
+var logFileNamePattern = regexp.MustCompile(`[^a-zA-Z0-9_.=,-]+`)
+
+// logFileName returns the log file name for a test ID.
+func logFileName(test string) string {
+	return strings.Trim(logFileNamePattern.ReplaceAllString(test, "_"), "_") + ".log"
+}
+
+// ConfigYAML returns the effective config of the runner as YAML, with the
+// defaults applied by NewRunner. Build environment values, build secret
+// paths, environment variables passed to docker run and all of the webhook
+// URL but its scheme and host are redacted.
+func (r *Runner) ConfigYAML() ([]byte, error) {
+	config := r.config
+	config.FailFast = &r.failFast
+	config.TTY = &r.tty
+	if config.SlowestN == nil {
+		slowestN := defaultSlowestN
+		config.SlowestN = &slowestN
+	}
+	config.BuildEnv = redactValues(r.config.BuildEnv)
+	config.BuildSecrets = redactValues(r.config.BuildSecrets)
+	config.DockerRunArgs = []string{strings.Join(redactArgs(r.runArgs), " ")}
+	config.WebhookURL = redactURL(r.config.WebhookURL)
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %v", err)
+	}
+	return data, nil
+}
+
+// redactValues returns a copy of m with every value redacted.
+func redactValues(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return m
+	}
+	result := make(map[string]string, len(m))
+	for k := range m {
+		result[k] = redacted
+	}
+	return result
+}
+
+// redactURL returns the scheme and host of a URL, as its user info, path and
+// query, e.g. of a chat webhook, may contain a token.
+func redactURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redacted
+	}
+	return u.Scheme + "://" + u.Host + "/" + redacted
+}
+
+// redactArgs returns docker arguments with the values of environment
+// variables set with -e or --env, and the paths of --env-file files,
+// replaced, as they may contain secrets or point to them.
+func redactArgs(args []string) []string {
+	result := make([]string, len(args))
+	copy(result, args)
+	for i, arg := range result {
+		var kv string
+		prefix := ""
+		switch {
+		case arg == "--env-file" && i+1 < len(result):
+			result[i+1] = redacted
+			continue
+		case strings.HasPrefix(arg, "--env-file="):
+			result[i] = "--env-file=" + redacted
+			continue
+		case (arg == "-e" || arg == "--env") && i+1 < len(result):
+			kv = result[i+1]
+			i++
+		case strings.HasPrefix(arg, "--env="):
+			prefix, kv = "--env=", strings.TrimPrefix(arg, "--env=")
+		case strings.HasPrefix(arg, "-e=") && len(arg) > len("-e="):
+			prefix, kv = "-e=", strings.TrimPrefix(arg, "-e=")
+		case strings.HasPrefix(arg, "-e") && len(arg) > len("-e"):
+			prefix, kv = "-e", strings.TrimPrefix(arg, "-e")
+		default:
+			continue
+		}
+		if k, _, ok := strings.Cut(kv, "="); ok {
+			result[i] = prefix + k + "=" + redacted
+		}
+	}
+	return result
+}
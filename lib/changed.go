@@ -0,0 +1,53 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// errNoGitRepo is returned by changedPackageDirs when git is not available
+// or the directory is not in a git repository.
+var errNoGitRepo = errors.New("not in a git repository")
+
+// changedPackageDirs returns the absolute directories of the files changed
+// since the given git ref, as reported by git diff in dir, and of the
+// untracked files that are not ignored.
+func changedPackageDirs(dir, ref string) (map[string]bool, error) {
+	rootCmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	rootCmd.Dir = dir
+	root, err := rootCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoGitRepo, err)
+	}
+
+	verifyCmd := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	verifyCmd.Dir = dir
+	if err := verifyCmd.Run(); err != nil {
+		return nil, fmt.Errorf("invalid changed-since ref %q: not a commit", ref)
+	}
+
+	diffCmd := exec.Command("git", "diff", "--name-only", ref)
+	diffCmd.Dir = dir
+	output, err := diffCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff against %s: %v", ref, err)
+	}
+
+	untrackedCmd := exec.Command("git", "ls-files", "--others", "--exclude-standard", "--full-name")
+	untrackedCmd.Dir = dir
+	untracked, err := untrackedCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list untracked files: %v", err)
+	}
+
+	dirs := make(map[string]bool)
+	for _, file := range strings.Split(string(output)+"\n"+string(untracked), "\n") {
+		if file = strings.TrimSpace(file); file != "" {
+			dirs[filepath.Dir(filepath.Join(strings.TrimSpace(string(root)), file))] = true
+		}
+	}
+	return dirs, nil
+}
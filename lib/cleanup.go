@@ -0,0 +1,45 @@
+package e2e
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Image cleanup policies supported by the CleanupPolicy option.
+const (
+	CleanupAlways    = "always"
+	CleanupOnSuccess = "on-success"
+	CleanupNever     = "never"
+)
+
+func validateCleanupPolicy(policy string) error {
+	switch policy {
+	case "", CleanupAlways, CleanupOnSuccess, CleanupNever:
+		return nil
+	default:
+		return fmt.Errorf("invalid cleanup policy %q: must be one of %s, %s, %s", policy, CleanupAlways, CleanupOnSuccess, CleanupNever)
+	}
+}
+
+// removeImage removes the images built by Setup according to the cleanup
+// policy. With on-success, the image of a suite that failed, or did not run,
+// is kept so the failure can be reproduced with docker run.
+func (r *Runner) removeImage() {
+	if r.containerBuildImage == "" || r.config.ExecInto != "" {
+		return
+	}
+	switch r.config.CleanupPolicy {
+	case CleanupAlways:
+	case CleanupOnSuccess:
+		if !r.passed {
+			fmt.Printf("--- INFO: Keeping image %s of the failed suite for debugging\n", r.containerBuildImage)
+			return
+		}
+	default:
+		return
+	}
+	images := append([]string{r.containerBuildImage}, r.groupImages...)
+	if err := dockerRun(r.config.Verbosity, append([]string{"rmi", "--force"}, images...)...); err != nil {
+		fmt.Printf("--- INFO: Failed to remove images %s: %v\n", strings.Join(images, ", "), err)
+	}
+}
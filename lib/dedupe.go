@@ -0,0 +1,68 @@
+package e2e
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// volatileOutput matches the parts of test output that differ between
+// otherwise identical failures: timestamps, durations, pointers and
+// goroutine IDs.
+var volatileOutput = regexp.MustCompile(`\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?Z?|\d{2}:\d{2}:\d{2}(\.\d+)?|\b\d+(\.\d+)?(ns|µs|us|ms|s|m|h)\b|0x[0-9a-fA-F]+|goroutine \d+`)
+
+// failureFingerprint returns a hash of a failed test's output, normalized so
+// that failures with the same cause in different tests hash the same.
+func failureFingerprint(test, output string) string {
+	output = strings.ReplaceAll(output, test, "TEST")
+	output = volatileOutput.ReplaceAllString(output, "_")
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(output)))
+}
+
+// failureGroup is a set of failed tests with the same normalized output.
+type failureGroup struct {
+	tests  []string
+	output string
+}
+
+// groupFailures groups the failed tests by their failure fingerprint, in the
+// order of their first failure. Tests that failed because the run was
+// cancelled are left out.
+func (r *Runner) groupFailures() []failureGroup {
+	var groups []failureGroup
+	index := make(map[string]int)
+	for _, test := range r.failedTests {
+		if slices.Contains(r.cancelledTests, test) {
+			continue
+		}
+		output := r.testStreams[test].output
+		fingerprint := failureFingerprint(r.testName(test), output)
+		i, ok := index[fingerprint]
+		if !ok {
+			i = len(groups)
+			index[fingerprint] = i
+			groups = append(groups, failureGroup{output: output})
+		}
+		groups[i].tests = append(groups[i].tests, test)
+	}
+	return groups
+}
+
+// printFailureGroups prints the output of each group of identical failures
+// once, with the tests that failed with it.
+func (r *Runner) printFailureGroups() {
+	groups := r.groupFailures()
+	if len(groups) == 0 {
+		return
+	}
+	n := 0
+	for _, group := range groups {
+		n += len(group.tests)
+	}
+	fmt.Printf("\n=== FAILURES: %d distinct failures in %d tests\n", len(groups), n)
+	for _, group := range groups {
+		fmt.Printf("--- %s: %s\n%s", r.status("FAIL"), strings.Join(group.tests, ", "), r.failureOutput(group.output))
+	}
+}
@@ -0,0 +1,69 @@
+package e2e
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a compiler or vet error in a Go source file.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	if d.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
+
+// diagnosticPattern matches file:line[:column]: message diagnostics, which
+// docker build output prefixes with the build step and time.
+var diagnosticPattern = regexp.MustCompile(`(?m)(?:^|\s)([^\s:]+\.go):(\d+)(?::(\d+))?: (.+?)\s*$`)
+
+// parseDiagnostics extracts the Go diagnostics from go build, go test -c or
+// docker build output, in order and without duplicates.
+func parseDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	seen := make(map[Diagnostic]bool)
+	for _, m := range diagnosticPattern.FindAllStringSubmatch(output, -1) {
+		line, _ := strconv.Atoi(m[2])
+		column, _ := strconv.Atoi(m[3])
+		d := Diagnostic{File: strings.TrimPrefix(m[1], "./"), Line: line, Column: column, Message: m[4]}
+		if !seen[d] {
+			seen[d] = true
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	return diagnostics
+}
+
+// formatDiagnostics formats diagnostics grouped by file, in order of first
+// appearance.
+func formatDiagnostics(diagnostics []Diagnostic) string {
+	var files []string
+	byFile := make(map[string][]Diagnostic)
+	for _, d := range diagnostics {
+		if _, ok := byFile[d.File]; !ok {
+			files = append(files, d.File)
+		}
+		byFile[d.File] = append(byFile[d.File], d)
+	}
+	var b strings.Builder
+	for _, file := range files {
+		fmt.Fprintf(&b, "%s:\n", file)
+		for _, d := range byFile[file] {
+			if d.Column > 0 {
+				fmt.Fprintf(&b, "  %d:%d: %s\n", d.Line, d.Column, d.Message)
+			} else {
+				fmt.Fprintf(&b, "  %d: %s\n", d.Line, d.Message)
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
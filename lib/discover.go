@@ -0,0 +1,210 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"go/build/constraint"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// TestInfo describes a test function found by DiscoverTests.
+type TestInfo struct {
+	Name string
+	// File is the path of the test's source file.
+	File string
+	// BuildConstraint is the file's //go:build expression, if any.
+	BuildConstraint string
+	// Excluded is why the test would not be run, e.g. because its build
+	// constraint is not satisfied by the build tags or it does not match
+	// TestPattern, or "" if it would be.
+	Excluded string
+
+	// skipped reports whether the test was excluded by SkipPattern, and
+	// envSkipped whether by its skip-unless-env annotation.
+	skipped    bool
+	envSkipped bool
+}
+
+// DiscoverTests returns the test functions in TestDir, or in TestFiles, and
+// whether each would be run, without building or running anything or
+// needing docker. Setup discovers the tests to run the same way.
+func (r *Runner) DiscoverTests() ([]TestInfo, error) {
+	if err := r.resolveBuildTags(); err != nil {
+		return nil, err
+	}
+	return r.discoverTests()
+}
+
+// discoverTests parses the test files for test functions, applying the
+// annotations of the tests selected to run.
+func (r *Runner) discoverTests() ([]TestInfo, error) {
+	var tests []TestInfo
+	fset := token.NewFileSet()
+
+	// Split pattern by slashes if present.
+	// Match behaviour in https://pkg.go.dev/cmd/go/internal/test
+	var patterns []*regexp.Regexp
+	if r.config.TestPattern != "" {
+		for _, p := range strings.Split(r.config.TestPattern, "/") {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid test pattern: %v", err)
+			}
+			patterns = append(patterns, re)
+		}
+	}
+
+	// Restrict to packages changed since the given ref, if any.
+	var changedDirs map[string]bool
+	if r.config.ChangedSince != "" {
+		var err error
+		changedDirs, err = changedPackageDirs(r.config.TestDir, r.config.ChangedSince)
+		if errors.Is(err, errNoGitRepo) {
+			fmt.Printf("--- INFO: Running all tests, could not determine changes since %s: %v\n", r.config.ChangedSince, err)
+		} else if err != nil {
+			return nil, err
+		} else if r.config.Verbosity > 2 {
+			fmt.Printf("--- DEBUG: Found %d directories changed since %s\n", len(changedDirs), r.config.ChangedSince)
+		}
+	}
+
+	// Test files unchanged since the last discovery are not parsed again.
+	cached := r.loadDiscoveryCache()
+	files := make(map[string]testFile)
+	var parsed bool
+
+	// parseFile adds the tests of a _test.go file.
+	parseFile := func(path string) error {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path: %v", err)
+		}
+
+		// Tests in a file are all excluded if its package has not changed or
+		// its build constraint is not satisfied.
+		var fileExcluded string
+		if changedDirs != nil {
+			// git reports paths with symlinks resolved.
+			dir, err := filepath.EvalSymlinks(filepath.Dir(absPath))
+			if err != nil {
+				return fmt.Errorf("failed to resolve path: %v", err)
+			}
+			if !changedDirs[dir] {
+				fileExcluded = fmt.Sprintf("package not changed since %s", r.config.ChangedSince)
+			}
+		}
+
+		// Parse the file for test functions and build constraints.
+		file, fileParsed, err := parseTestFile(fset, path, absPath, cached)
+		if err != nil {
+			return err
+		}
+		files[absPath] = file
+		parsed = parsed || fileParsed
+		var expr constraint.Expr
+		if file.BuildConstraint != "" {
+			if expr, err = constraint.Parse("//go:build " + file.BuildConstraint); err != nil {
+				return fmt.Errorf("invalid build constraint in %s: %v", path, err)
+			}
+		}
+
+		// With build tags configured, exclude files whose build
+		// constraints they don't satisfy.
+		if fileExcluded == "" && r.buildTags != nil && expr != nil && !satisfiesBuildTags(expr, r.implicitTags(), r.buildTagSet()) {
+			fileExcluded = fmt.Sprintf("build constraint %q not satisfied", file.BuildConstraint)
+		}
+		if fileExcluded != "" && r.config.Verbosity > 1 {
+			fmt.Printf("--- DEBUG: Excluding %s: %s\n", path, fileExcluded)
+		}
+
+		for _, fn := range file.Tests {
+			name := fn.Name
+			if name == "TestMain" {
+				r.logExcluded(name, path, "TestMain is not a test")
+				continue
+			}
+			info := TestInfo{Name: name, File: path, BuildConstraint: file.BuildConstraint, Excluded: fileExcluded}
+			switch {
+			case info.Excluded != "":
+			case !matchesPatterns(name, patterns):
+				info.Excluded = fmt.Sprintf("does not match run pattern %q", r.config.TestPattern)
+				r.logExcluded(name, path, info.Excluded)
+
+			// Skip tests matching the skip pattern, which wins over the
+			// include pattern.
+			case r.skipPattern != nil && r.skipPattern.MatchString(name):
+				info.Excluded = fmt.Sprintf("matches skip pattern %q", r.config.SkipPattern)
+				info.skipped = true
+				r.logExcluded(name, path, info.Excluded)
+
+			default:
+				if err := r.applyAnnotations(name, fn.doc()); err != nil {
+					return fmt.Errorf("%s in %s: %v", name, path, err)
+				}
+				if missing := r.missingEnv(name); len(missing) > 0 {
+					info.Excluded = fmt.Sprintf("environment variable %s not set", strings.Join(missing, ", "))
+					info.envSkipped = true
+					r.logExcluded(name, path, info.Excluded)
+					break
+				}
+				if r.testDirs == nil {
+					r.testDirs = make(map[string][]string)
+				}
+				if dir := filepath.Dir(path); !slices.Contains(r.testDirs[name], dir) {
+					r.testDirs[name] = append(r.testDirs[name], dir)
+				}
+			}
+			tests = append(tests, info)
+		}
+		return nil
+	}
+
+	var err error
+	if len(r.config.TestFiles) > 0 {
+		for _, file := range r.config.TestFiles {
+			if err = parseFile(filepath.Join(r.config.TestDir, file)); err != nil {
+				break
+			}
+		}
+	} else {
+		err = filepath.Walk(r.config.TestDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && path != r.config.TestDir && len(r.config.ExcludeDirs) > 0 {
+				rel, err := filepath.Rel(r.config.TestDir, path)
+				if err != nil {
+					return fmt.Errorf("failed to get relative path: %v", err)
+				}
+				if excludedDir(filepath.ToSlash(rel), r.config.ExcludeDirs) {
+					if r.config.Verbosity > 2 {
+						fmt.Printf("--- DEBUG: Ignoring directory %s because it matches exclude-dirs\n", path)
+					}
+					return filepath.SkipDir
+				}
+			}
+			if !info.IsDir() && strings.HasSuffix(path, "_test.go") {
+				return parseFile(path)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tests: %v", err)
+	}
+	// Entries of other files are kept by saveDiscoveryCache, so it is only
+	// saved when a file was parsed.
+	if parsed {
+		if err := r.saveDiscoveryCache(files); err != nil {
+			fmt.Printf("--- INFO: Failed to save discovery cache: %v\n", err)
+		}
+	} else if r.config.Verbosity > 2 {
+		fmt.Printf("--- DEBUG: Found tests in %d unchanged files from the discovery cache\n", len(files))
+	}
+	return tests, nil
+}
@@ -0,0 +1,167 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// testFile is what discovery needs of a parsed _test.go file. It is cached
+// with the size and modification time the file had, so that unchanged files
+// are not parsed again; see NoDiscoveryCache.
+type testFile struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mod_time"`
+	// BuildConstraint is the file's //go:build expression, if any.
+	BuildConstraint string     `json:"build_constraint,omitempty"`
+	Tests           []testFunc `json:"tests"`
+}
+
+// testFunc is a Test function of a test file, with the go-e2e annotation
+// lines of its doc comment.
+type testFunc struct {
+	Name        string   `json:"name"`
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+// doc returns the test's annotations as a doc comment for applyAnnotations.
+func (f testFunc) doc() *ast.CommentGroup {
+	if len(f.Annotations) == 0 {
+		return nil
+	}
+	doc := &ast.CommentGroup{}
+	for _, text := range f.Annotations {
+		doc.List = append(doc.List, &ast.Comment{Text: text})
+	}
+	return doc
+}
+
+// discoveryCacheFilePath returns the discovery cache file path for the given
+// test directory.
+func discoveryCacheFilePath(testDir string) (string, error) {
+	return cacheFilePath("discovery", testDir)
+}
+
+// loadDiscoveryCache returns the test files cached by the last discovery in
+// TestDir, keyed by absolute path, or nil if there are none or the cache is
+// disabled.
+func (r *Runner) loadDiscoveryCache() map[string]testFile {
+	if r.config.NoDiscoveryCache {
+		return nil
+	}
+	path, err := discoveryCacheFilePath(r.config.TestDir)
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var files map[string]testFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		if r.config.Verbosity > 1 {
+			fmt.Printf("--- DEBUG: Ignoring invalid discovery cache %s: %v\n", path, err)
+		}
+		return nil
+	}
+	return files
+}
+
+// saveDiscoveryCache adds the discovered test files to the discovery cache,
+// unless it is disabled. Files cached by other runs in TestDir are kept
+// while they exist, and the cache is replaced atomically, so that runs
+// discovering concurrently do not drop or corrupt each other's entries.
+func (r *Runner) saveDiscoveryCache(files map[string]testFile) error {
+	if r.config.NoDiscoveryCache {
+		return nil
+	}
+	path, err := discoveryCacheFilePath(r.config.TestDir)
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[string]testFile, len(files))
+	for key, file := range r.loadDiscoveryCache() {
+		if _, err := os.Stat(key); err == nil {
+			merged[key] = file
+		}
+	}
+	for key, file := range files {
+		merged[key] = file
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("failed to marshal discovery cache: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write discovery cache: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write discovery cache: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write discovery cache: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write discovery cache: %v", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to write discovery cache: %v", err)
+	}
+	return nil
+}
+
+// parseTestFile returns the build constraint and Test functions of a test
+// file, from the entry for key in cached if the file has the same size and
+// modification time as when it was cached, and whether it had to be parsed.
+func parseTestFile(fset *token.FileSet, path, key string, cached map[string]testFile) (testFile, bool, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return testFile{}, false, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	file := testFile{Size: stat.Size(), ModTime: stat.ModTime().UnixNano()}
+	if prev, ok := cached[key]; ok && prev.Size == file.Size && prev.ModTime == file.ModTime {
+		return prev, false, nil
+	}
+
+	f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+	if err != nil {
+		return testFile{}, false, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	expr, err := fileBuildConstraint(f)
+	if err != nil {
+		return testFile{}, false, fmt.Errorf("invalid build constraint in %s: %v", path, err)
+	}
+	if expr != nil {
+		file.BuildConstraint = expr.String()
+	}
+	for _, decl := range f.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || !strings.HasPrefix(funcDecl.Name.Name, "Test") {
+			continue
+		}
+		fn := testFunc{Name: funcDecl.Name.Name}
+		if funcDecl.Doc != nil {
+			for _, c := range funcDecl.Doc.List {
+				if strings.HasPrefix(c.Text, annotationPrefix) {
+					fn.Annotations = append(fn.Annotations, c.Text)
+				}
+			}
+		}
+		file.Tests = append(file.Tests, fn)
+	}
+	return file, true, nil
+}
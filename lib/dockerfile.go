@@ -0,0 +1,55 @@
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// writeInlineDockerfile writes the Dockerfile content to the run's temporary
+// directory, and returns the path of the written Dockerfile.
+func (r *Runner) writeInlineDockerfile(content string) (string, error) {
+	dir, err := r.makeTmpDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "Dockerfile")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write inline Dockerfile: %v", err)
+	}
+	return path, nil
+}
+
+// generateDockerfile runs DockerfileCommand on the host in TestDir and
+// returns its stdout as the Dockerfile content.
+func (r *Runner) generateDockerfile() (string, error) {
+	cmd := exec.Command("sh", "-c", r.config.DockerfileCommand)
+	cmd.Dir = r.config.TestDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("dockerfile command failed: %v\n%s", err, stderr.String())
+	}
+	if strings.TrimSpace(string(output)) == "" {
+		return "", fmt.Errorf("dockerfile command printed no Dockerfile")
+	}
+	return string(output), nil
+}
+
+// countSet returns the number of non-empty values.
+func countSet(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,90 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrDockerfileNotFound is returned by Setup when the Dockerfile does not exist.
+	ErrDockerfileNotFound = errors.New("dockerfile not found")
+
+	// ErrDaemonUnavailable is returned by Setup when docker is not installed or
+	// the docker daemon cannot be reached.
+	ErrDaemonUnavailable = errors.New("docker daemon unavailable")
+
+	// ErrBuildFailed is returned by Setup, wrapped in a *BuildError, when the
+	// docker build fails.
+	ErrBuildFailed = errors.New("failed to build docker image")
+
+	// ErrNoTests is returned by Setup with FailOnNoTests when no tests match
+	// the filters.
+	ErrNoTests = errors.New("no tests found")
+)
+
+// BuildError is returned by Setup when the docker build, or the test binary
+// build with MountBinary, fails. It wraps ErrBuildFailed.
+type BuildError struct {
+	// Output is the combined output of the build.
+	Output string
+
+	// Diagnostics are the Go compiler and vet errors in Output, if the build
+	// failed to compile the code.
+	Diagnostics []Diagnostic
+
+	// what describes the failed build, if not the docker image.
+	what string
+
+	// streamed is true if the output was already printed during the build,
+	// in which case it is left out of the error message.
+	streamed bool
+}
+
+// newBuildError returns a BuildError for the output of a failed build.
+func newBuildError(output string, streamed bool) *BuildError {
+	return &BuildError{Output: output, Diagnostics: parseDiagnostics(output), streamed: streamed}
+}
+
+// Error returns the build's diagnostics, grouped by file, if it failed to
+// compile the code, or else its output unless already streamed.
+func (e *BuildError) Error() string {
+	msg := ErrBuildFailed.Error()
+	if e.what != "" {
+		msg = "failed to build " + e.what
+	}
+	if len(e.Diagnostics) > 0 {
+		noun := "compile errors"
+		if len(e.Diagnostics) == 1 {
+			noun = "compile error"
+		}
+		return fmt.Sprintf("%s: %d %s\n%s", msg, len(e.Diagnostics), noun, formatDiagnostics(e.Diagnostics))
+	}
+	if e.streamed || e.Output == "" {
+		return msg
+	}
+	return msg + "\n" + e.Output
+}
+
+func (e *BuildError) Unwrap() error {
+	return ErrBuildFailed
+}
+
+// daemonUnavailableMessages are substrings of docker output indicating that
+// the daemon cannot be reached.
+var daemonUnavailableMessages = []string{
+	"Cannot connect to the Docker daemon",
+	"error during connect",
+	"Is the docker daemon running?",
+}
+
+// isDaemonUnavailableOutput reports whether docker output indicates that the
+// daemon cannot be reached.
+func isDaemonUnavailableOutput(output string) bool {
+	for _, msg := range daemonUnavailableMessages {
+		if strings.Contains(output, msg) {
+			return true
+		}
+	}
+	return false
+}
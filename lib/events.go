@@ -0,0 +1,104 @@
+package e2e
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of an Event.
+type EventType string
+
+const (
+	// EventRunStart is sent once before any test is started.
+	EventRunStart EventType = "run-start"
+	// EventTestStart is sent when a test's container is started.
+	EventTestStart EventType = "test-start"
+	// EventTestFinish is sent when a test has passed or failed.
+	EventTestFinish EventType = "test-finish"
+	// EventSuiteDone is sent once after all tests have finished.
+	EventSuiteDone EventType = "suite-done"
+)
+
+// Event describes progress of a test run; see RunnerConfig.OnEvent.
+type Event struct {
+	Type EventType
+	// Test is the test name, set for test events.
+	Test string
+	// Status is PASS or FAIL, or SKIP for tests that called t.Skip with
+	// ReportSkips, set for EventTestFinish and EventSuiteDone. The status of
+	// EventSuiteDone is STOP if the run's context was done before all tests
+	// finished and none failed.
+	Status string
+	// Duration is the test duration for EventTestFinish and the run duration
+	// for EventSuiteDone.
+	Duration time.Duration
+}
+
+// emit queues an event for the OnEvent callback, if any. It never blocks on
+// the callback.
+func (r *Runner) emit(e Event) {
+	if r.events != nil {
+		r.events.push(e)
+	}
+}
+
+// eventQueue delivers events in order to a callback from its own goroutine,
+// so that a slow callback does not delay the tests. The queue is unbounded,
+// so pushing never blocks.
+type eventQueue struct {
+	mu      sync.Mutex
+	pending []Event
+	closed  bool
+	// ready is signalled when events are pushed or the queue is closed, and
+	// done is closed once the delivery goroutine exits.
+	ready chan struct{}
+	done  chan struct{}
+}
+
+// newEventQueue returns an eventQueue delivering events to fn.
+func newEventQueue(fn func(Event)) *eventQueue {
+	q := &eventQueue{ready: make(chan struct{}, 1), done: make(chan struct{})}
+	go q.deliver(fn)
+	return q
+}
+
+// push queues an event for delivery.
+func (q *eventQueue) push(e Event) {
+	q.mu.Lock()
+	q.pending = append(q.pending, e)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// close waits for the queued events to be delivered and stops delivery.
+// Events must not be pushed after close.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+	<-q.done
+}
+
+func (q *eventQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *eventQueue) deliver(fn func(Event)) {
+	defer close(q.done)
+	for range q.ready {
+		q.mu.Lock()
+		events, closed := q.pending, q.closed
+		q.pending = nil
+		q.mu.Unlock()
+		for _, e := range events {
+			fn(e)
+		}
+		if closed {
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package e2e
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// validateExcludeDirs checks that the exclude patterns are valid globs.
+func validateExcludeDirs(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude dir %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// excludedDir reports whether the directory at dir, a slash-separated path
+// relative to TestDir, matches one of the exclude patterns. A pattern
+// matches a directory whose path or name it matches as a glob, and the
+// directories under a path it is a prefix of.
+func excludedDir(dir string, patterns []string) bool {
+	name := path.Base(dir)
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if ok, _ := path.Match(pattern, dir); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if strings.HasPrefix(dir, pattern+"/") {
+			return true
+		}
+	}
+	return false
+}
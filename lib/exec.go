@@ -0,0 +1,52 @@
+package e2e
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// checkExecContainer checks that the ExecInto container is running.
+func (r *Runner) checkExecContainer() error {
+	cmd := exec.Command("docker", "inspect", "--format", "{{.State.Running}}", r.config.ExecInto)
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to inspect container %s: %v\n%s", r.config.ExecInto, err, output)
+	}
+	if strings.TrimSpace(string(output)) != "true" {
+		return fmt.Errorf("container %s is not running", r.config.ExecInto)
+	}
+	fmt.Printf("--- INFO: Running tests in container %s\n", r.config.ExecInto)
+	r.execContainer = r.config.ExecInto
+	r.execBinary = r.config.Entrypoint
+	return nil
+}
+
+// dockerExecArgs returns the docker exec arguments for running a test in the
+// ExecInto or shared container.
+func (r *Runner) dockerExecArgs(test string) []string {
+	name := r.testName(test)
+	args := []string{"exec"}
+	if r.tty {
+		args = append(args, "--tty")
+	}
+	if r.runID != "" {
+		args = append(args, "-e", "E2E_RUN_ID="+r.runID)
+	}
+	args = append(args, r.matrixEnvArgs(test)...)
+	if r.config.User != "" {
+		args = append(args, "--user", r.config.User)
+	}
+	if r.config.AssetsMountPath != "" {
+		args = append(args, "--workdir", r.config.AssetsMountPath)
+	}
+	args = append(args, r.execContainer, r.execBinary, "-test.run", r.runPattern(name))
+	if r.config.Verbosity > 0 || r.config.ReportSkips {
+		args = append(args, "-test.v")
+	}
+	args = append(args, r.shuffleArgs()...)
+	return append(args, r.testFlags...)
+}
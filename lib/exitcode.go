@@ -0,0 +1,92 @@
+package e2e
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TestsFailedError is returned by RunTests when one or more tests failed, and
+// by RunTestsContext also when ctx was done before all tests finished.
+type TestsFailedError struct {
+	Passed     int
+	Failed     int
+	Incomplete int
+
+	// Cancelled is the number of failed tests that were killed because the run
+	// was cancelled, either by fail-fast or by the caller's context, rather
+	// than failing on their own. It is included in Failed.
+	Cancelled int
+
+	// Err is the error of the caller's context if it was done before all
+	// tests finished, in which case it is returned even if no test failed.
+	Err error
+}
+
+func (e *TestsFailedError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("tests stopped: %v (%d passed, %d failed, %d incomplete)", e.Err, e.Passed, e.Failed, e.Incomplete)
+	}
+	return fmt.Sprintf("tests failed (%d passed, %d failed, %d incomplete)", e.Passed, e.Failed, e.Incomplete)
+}
+
+func (e *TestsFailedError) Unwrap() error {
+	return e.Err
+}
+
+// Exit code policies supported by the ExitCodePolicy option.
+const (
+	// ExitCodePolicyAnyFailure fails the run if any test failed. This is the
+	// default.
+	ExitCodePolicyAnyFailure = "any-failure"
+	// ExitCodePolicyIgnoreIncomplete fails the run only if a test failed on
+	// its own, ignoring tests that were killed because the run was cancelled.
+	ExitCodePolicyIgnoreIncomplete = "ignore-incomplete"
+	// ExitCodePolicyThreshold fails the run only if more than N tests failed,
+	// and is written as "threshold:N".
+	ExitCodePolicyThreshold = "threshold"
+)
+
+// ExitCodePolicy decides whether a run with failed tests should fail.
+type ExitCodePolicy struct {
+	name      string
+	threshold int
+}
+
+// ParseExitCodePolicy parses an exit code policy. An empty string is the
+// default any-failure policy.
+func ParseExitCodePolicy(s string) (ExitCodePolicy, error) {
+	switch {
+	case s == "" || s == ExitCodePolicyAnyFailure:
+		return ExitCodePolicy{name: ExitCodePolicyAnyFailure}, nil
+	case s == ExitCodePolicyIgnoreIncomplete:
+		return ExitCodePolicy{name: ExitCodePolicyIgnoreIncomplete}, nil
+	case strings.HasPrefix(s, ExitCodePolicyThreshold+":"):
+		n, err := strconv.Atoi(strings.TrimPrefix(s, ExitCodePolicyThreshold+":"))
+		if err != nil || n < 0 {
+			return ExitCodePolicy{}, fmt.Errorf("invalid exit code policy %q: threshold must be a non-negative integer", s)
+		}
+		return ExitCodePolicy{name: ExitCodePolicyThreshold, threshold: n}, nil
+	default:
+		return ExitCodePolicy{}, fmt.Errorf("invalid exit code policy %q: must be one of %s, %s, %s:N", s, ExitCodePolicyAnyFailure, ExitCodePolicyIgnoreIncomplete, ExitCodePolicyThreshold)
+	}
+}
+
+// Fails reports whether the given test failures should fail the run.
+func (p ExitCodePolicy) Fails(e *TestsFailedError) bool {
+	switch p.name {
+	case ExitCodePolicyIgnoreIncomplete:
+		return e.Failed-e.Cancelled > 0
+	case ExitCodePolicyThreshold:
+		return e.Failed > p.threshold
+	default:
+		return e.Failed > 0 || e.Err != nil
+	}
+}
+
+func (p ExitCodePolicy) String() string {
+	if p.name == ExitCodePolicyThreshold {
+		return fmt.Sprintf("%s:%d", p.name, p.threshold)
+	}
+	return p.name
+}
@@ -0,0 +1,54 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// fixturesDir is the directory in TestDir that holds per-test fixtures in
+	// fixtures/<TestName>/ subdirectories.
+	fixturesDir = "fixtures"
+
+	// fixturesMountPath is where a test's fixtures are mounted in its container.
+	fixturesMountPath = "/fixtures"
+)
+
+// findTestFixtures returns the absolute fixtures directory of each test that
+// has one, keyed by test function name, which matrix runs of the test share.
+func (r *Runner) findTestFixtures() (map[string]string, error) {
+	fixtures := make(map[string]string)
+	for _, id := range r.testsToRun {
+		test := r.testName(id)
+		if _, ok := fixtures[test]; ok {
+			continue
+		}
+		dir, err := filepath.Abs(filepath.Join(r.config.TestDir, fixturesDir, test))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %v", err)
+		}
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		if r.config.Verbosity > 2 {
+			fmt.Printf("--- DEBUG: Found fixtures for %s: %s\n", test, dir)
+		}
+		fixtures[test] = dir
+	}
+	return fixtures, nil
+}
+
+// fixtureArgs returns the docker run arguments that mount the fixtures of a
+// test function, if it has any.
+func (r *Runner) fixtureArgs(test string) []string {
+	dir, ok := r.testFixtures[test]
+	if !ok {
+		return nil
+	}
+	return []string{
+		"-v", dir + ":" + fixturesMountPath + ":ro",
+		"-e", "E2E_FIXTURES_DIR=" + fixturesMountPath,
+	}
+}
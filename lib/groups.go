@@ -0,0 +1,59 @@
+package e2e
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DockerfileGroup runs the tests matching TestPattern in an image built from
+// its own Dockerfile.
+type DockerfileGroup struct {
+	Dockerfile  string `yaml:"dockerfile"`
+	TestPattern string `yaml:"test-pattern"`
+}
+
+// compileDockerfileGroups validates the Dockerfile groups and compiles their
+// test patterns.
+func compileDockerfileGroups(config RunnerConfig) ([]*regexp.Regexp, error) {
+	if len(config.DockerfileGroups) == 0 {
+		return nil, nil
+	}
+	if config.ExecInto != "" || config.SharedContainer || config.PushImage {
+		return nil, fmt.Errorf("dockerfile-groups cannot be combined with exec-into, shared-container or push-image")
+	}
+	patterns := make([]*regexp.Regexp, len(config.DockerfileGroups))
+	for i, group := range config.DockerfileGroups {
+		if group.Dockerfile == "" || group.TestPattern == "" {
+			return nil, fmt.Errorf("invalid dockerfile group %d: dockerfile and test-pattern are required", i+1)
+		}
+		re, err := regexp.Compile(group.TestPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dockerfile group %d test pattern: %v", i+1, err)
+		}
+		patterns[i] = re
+	}
+	return patterns, nil
+}
+
+// groupImageName returns the name of the image built for the Dockerfile group
+// at index i, derived from the suite's image name.
+func groupImageName(image string, i int) string {
+	suffix := fmt.Sprintf("-group%d", i+1)
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		return image[:colon] + suffix + image[colon:]
+	}
+	return image + suffix
+}
+
+// imageFor returns the image to run a test in: that of the first Dockerfile
+// group whose pattern matches the test, or the suite's image.
+func (r *Runner) imageFor(test string) string {
+	name := r.testName(test)
+	for i, re := range r.groupPatterns {
+		if re.MatchString(name) {
+			return r.groupImages[i]
+		}
+	}
+	return r.containerBuildImage
+}
@@ -0,0 +1,60 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	healthCheckTimeout  = 30 * time.Second
+	healthCheckInterval = 500 * time.Millisecond
+)
+
+// checkTestHealth polls the URL of a passing test's healthcheck annotation
+// from the host until it responds with a 2xx status, failing the test if it
+// doesn't within healthCheckTimeout. The reason is appended to the test's
+// output.
+func (r *Runner) checkTestHealth(ctx context.Context, test string, output io.Writer) error {
+	url, ok := r.testHealthChecks[r.testName(test)]
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	client := &http.Client{Timeout: 2 * time.Second}
+	for {
+		err := checkHealthURL(ctx, client, url)
+		if err == nil {
+			return nil
+		}
+		if r.config.Verbosity > 2 {
+			fmt.Printf("--- DEBUG: %s health check %s not ready: %v\n", test, url, err)
+		}
+		select {
+		case <-ctx.Done():
+			fmt.Fprintf(output, "\n--- ERROR: Health check %s did not pass within %s: %v\n", url, healthCheckTimeout, err)
+			return fmt.Errorf("health check %s failed: %v", url, err)
+		case <-time.After(healthCheckInterval):
+		}
+	}
+}
+
+// checkHealthURL returns nil if a GET of url responds with a 2xx status.
+func checkHealthURL(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
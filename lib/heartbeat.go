@@ -0,0 +1,35 @@
+package e2e
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// startHeartbeat prints how long ago start was to w every interval, until
+// the returned stop function is called, so that a long, quiet step does not
+// look hung. An interval of 0 disables it.
+func startHeartbeat(w io.Writer, what string, start time.Time, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintf(w, "--- INFO: Still %s... %ds elapsed\n", what, int(time.Since(start).Seconds()))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,99 @@
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// runHook runs a before-each or after-each command for a test on the host,
+// writing its output to stdout and stderr. Hooks are run one at a time, even
+// when tests run in parallel.
+func (r *Runner) runHook(name, command, test string, stdout, stderr io.Writer) error {
+	if command == "" {
+		return nil
+	}
+
+	r.hookMu.Lock()
+	defer r.hookMu.Unlock()
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = r.config.TestDir
+	cmd.Env = append(os.Environ(), "E2E_TEST="+r.testName(test))
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running %s command for %s: %s\n", name, test, command)
+	}
+	if err := cmd.Run(); err != nil {
+		err = fmt.Errorf("%s command failed: %v", name, err)
+		fmt.Fprintf(stderr, "--- ERROR: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// runSuiteCommand runs the setup or teardown command of the suite: on the
+// host, or with SetupImage in a throwaway container joined to the network
+// the tests run on, so that it can reach services only on that network. Its
+// output is printed if it fails, or streamed at verbosity > 0.
+func (r *Runner) runSuiteCommand(name, command string) error {
+	if command == "" {
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	if r.config.SetupImage != "" {
+		args := []string{"run", "--rm"}
+		if network := r.testNetwork(); network != "" {
+			args = append(args, "--network", network)
+		}
+		args = append(args, "--entrypoint", "sh", r.config.SetupImage, "-c", command)
+		cmd = exec.Command("docker", args...)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+		cmd.Dir = r.config.TestDir
+	}
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+	}
+	var output bytes.Buffer
+	if r.config.Verbosity > 0 {
+		cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+		cmd.Stderr = io.MultiWriter(os.Stderr, &output)
+	} else {
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+	}
+
+	fmt.Printf("--- INFO: Running %s command...\n", name)
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		if r.config.Verbosity > 0 {
+			return fmt.Errorf("%s command failed: %v", name, err)
+		}
+		return fmt.Errorf("%s command failed: %v\n%s", name, err, output.String())
+	}
+	fmt.Printf("--- OK: %s command (%.2fs)\n", name, time.Since(start).Seconds())
+	return nil
+}
+
+// testNetwork returns the network the test containers are attached to with
+// --network or --net in DockerRunArgs, if any.
+func (r *Runner) testNetwork() string {
+	for i, arg := range r.runArgs {
+		for _, flag := range []string{"--network", "--net"} {
+			if arg == flag && i+1 < len(r.runArgs) {
+				return r.runArgs[i+1]
+			}
+			if network, ok := strings.CutPrefix(arg, flag+"="); ok {
+				return network
+			}
+		}
+	}
+	return ""
+}
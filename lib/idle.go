@@ -0,0 +1,52 @@
+package e2e
+
+import (
+	"sync"
+	"time"
+)
+
+// idleWatcher is an io.Writer that calls a function if nothing is written to
+// it for a timeout, to detect hung tests by their output.
+type idleWatcher struct {
+	timeout time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	fired   bool
+	stopped bool
+}
+
+// watchIdle starts an idleWatcher that calls onIdle once if nothing is
+// written to it for timeout.
+func watchIdle(timeout time.Duration, onIdle func()) *idleWatcher {
+	w := &idleWatcher{timeout: timeout}
+	w.timer = time.AfterFunc(timeout, func() {
+		w.mu.Lock()
+		if w.stopped {
+			w.mu.Unlock()
+			return
+		}
+		w.fired = true
+		w.mu.Unlock()
+		onIdle()
+	})
+	return w
+}
+
+func (w *idleWatcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stopped && !w.fired {
+		w.timer.Reset(w.timeout)
+	}
+	return len(p), nil
+}
+
+// Stop stops watching and reports whether the timeout was reached.
+func (w *idleWatcher) Stop() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+	w.timer.Stop()
+	return w.fired
+}
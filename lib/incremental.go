@@ -0,0 +1,162 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// filterUnchangedTests removes the tests that passed in the last run and
+// whose package sources have not changed since. New, changed and previously
+// failed tests are kept.
+func (r *Runner) filterUnchangedTests() error {
+	state, err := loadState(r.config.TestDir)
+	if err != nil {
+		fmt.Printf("--- INFO: Running all tests: %v\n", err)
+		return nil
+	}
+	hashes, err := r.testSourceHashes()
+	if err != nil {
+		fmt.Printf("--- INFO: Running all tests: %v\n", err)
+		return nil
+	}
+
+	var tests []string
+	for _, t := range r.testsToRun {
+		// A test name found in several packages is skipped only if it is
+		// unchanged in all of them.
+		for _, key := range r.testStateKeys(t) {
+			last, ok := state.Tests[key]
+			if !ok || !last.Passed || last.Hash == "" || last.Hash != hashes[key] {
+				tests = append(tests, t)
+				break
+			}
+		}
+	}
+	fmt.Printf("--- INFO: Skipping %d tests that passed in the last run and are unchanged\n", len(r.testsToRun)-len(tests))
+	r.testsToRun = tests
+	return nil
+}
+
+// testStateKeys returns the keys of a test name in the state's Tests, which
+// are its package directory relative to TestDir joined with the name, once
+// for each package the name was found in.
+func (r *Runner) testStateKeys(name string) []string {
+	var keys []string
+	for _, dir := range r.testDirs[name] {
+		rel, err := filepath.Rel(r.config.TestDir, dir)
+		if err != nil {
+			rel = dir
+		}
+		keys = append(keys, path.Join(filepath.ToSlash(rel), name))
+	}
+	return keys
+}
+
+// testSourceHashes returns the hash of the sources each test found by
+// getTestsToRun depends on, keyed by testStateKeys.
+func (r *Runner) testSourceHashes() (map[string]string, error) {
+	dirHashes := make(map[string]string)
+	hashes := make(map[string]string, len(r.testDirs))
+	for name, dirs := range r.testDirs {
+		for _, dir := range dirs {
+			if _, ok := dirHashes[dir]; !ok {
+				h, err := r.hashPackageSources(dir)
+				if err != nil {
+					return nil, err
+				}
+				dirHashes[dir] = h
+			}
+		}
+		for i, key := range r.testStateKeys(name) {
+			hashes[key] = dirHashes[dirs[i]]
+		}
+	}
+	return hashes, nil
+}
+
+// hashPackageSources returns a hash of the .go files of the package in dir
+// and of the packages of the main module it imports, directly or not, along
+// with the go.mod and go.sum files. Dependencies outside the main module are
+// covered by go.sum.
+func (r *Runner) hashPackageSources(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	args := []string{"list", "-e", "-deps", "-test", "-f", "{{if and (not .Standard) .Module .Module.Main}}{{.Dir}}{{end}}"}
+	if len(r.buildTags) > 0 {
+		args = append(args, "-tags", strings.Join(r.buildTags, ","))
+	}
+	cmd := exec.Command("go", append(args, ".")...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to list dependencies of %s: %v\n%s", dir, err, stderr.String())
+	}
+	deps := map[string]bool{dir: true}
+	for _, dep := range strings.Fields(string(output)) {
+		deps[dep] = true
+	}
+	sorted := make([]string, 0, len(deps))
+	for dep := range deps {
+		sorted = append(sorted, dep)
+	}
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	for _, dep := range sorted {
+		depHash, err := hashGoFiles(dep)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %s\n", dep, depHash)
+	}
+	if goMod, err := findGoMod(dir); err == nil {
+		for _, file := range []string{goMod, filepath.Join(filepath.Dir(goMod), "go.sum")} {
+			data, err := os.ReadFile(file)
+			if err != nil && !os.IsNotExist(err) {
+				return "", fmt.Errorf("failed to read %s: %v", file, err)
+			}
+			fmt.Fprintf(h, "%s %d\n", file, len(data))
+			h.Write(data)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashGoFiles returns a hash of the names and contents of the .go files in
+// dir, not including subdirectories.
+func hashGoFiles(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", dir, err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), ".go") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		fmt.Fprintf(h, "%s %d\n", name, len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
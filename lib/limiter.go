@@ -0,0 +1,36 @@
+package e2e
+
+import "context"
+
+// ContainerLimiter bounds the number of test containers running at once
+// across all runners sharing it, on top of each runner's Parallelism.
+type ContainerLimiter struct {
+	sem chan struct{}
+}
+
+// NewContainerLimiter returns a limiter allowing up to max containers to run
+// at once.
+func NewContainerLimiter(max int) *ContainerLimiter {
+	return &ContainerLimiter{sem: make(chan struct{}, max)}
+}
+
+// acquire waits for a container slot, returning false if ctx is done first.
+// A nil limiter never blocks.
+func (l *ContainerLimiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot taken by acquire.
+func (l *ContainerLimiter) release() {
+	if l != nil {
+		<-l.sem
+	}
+}
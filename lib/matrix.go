@@ -0,0 +1,136 @@
+package e2e
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// matrixCell is one combination of matrix environment variable values.
+type matrixCell struct {
+	// label identifies the cell, e.g. "DB=postgres,PG_VERSION=13".
+	label string
+	// env holds the cell's variables as KEY=VALUE, sorted by key.
+	env []string
+}
+
+// testCase is a test run under one matrix cell.
+type testCase struct {
+	name string
+	cell matrixCell
+}
+
+func validateMatrix(matrix map[string][]string) error {
+	for k, values := range matrix {
+		if k == "" || strings.Contains(k, "=") {
+			return fmt.Errorf("invalid matrix variable %q", k)
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("matrix variable %s has no values", k)
+		}
+	}
+	return nil
+}
+
+// expandMatrix returns the cartesian product of the matrix values, ordered by
+// variable name and then by the order of the values.
+func expandMatrix(matrix map[string][]string) []matrixCell {
+	keys := make([]string, 0, len(matrix))
+	for k := range matrix {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	cells := [][]string{{}}
+	for _, k := range keys {
+		var next [][]string
+		for _, cell := range cells {
+			for _, v := range matrix[k] {
+				next = append(next, append(append([]string{}, cell...), k+"="+v))
+			}
+		}
+		cells = next
+	}
+
+	result := make([]matrixCell, 0, len(cells))
+	for _, env := range cells {
+		result = append(result, matrixCell{label: strings.Join(env, ","), env: env})
+	}
+	return result
+}
+
+// expandTestsForMatrix replaces each test to run with one test case per
+// matrix cell, identified as "TestName [cell]" and grouped by cell.
+func (r *Runner) expandTestsForMatrix() {
+	if len(r.config.Matrix) == 0 {
+		return
+	}
+	cells := expandMatrix(r.config.Matrix)
+	r.testCases = make(map[string]testCase, len(cells)*len(r.testsToRun))
+	ids := make([]string, 0, len(cells)*len(r.testsToRun))
+	for _, cell := range cells {
+		for _, test := range r.testsToRun {
+			id := fmt.Sprintf("%s [%s]", test, cell.label)
+			r.testCases[id] = testCase{name: test, cell: cell}
+			ids = append(ids, id)
+		}
+	}
+	r.testsToRun = ids
+	fmt.Printf("--- INFO: Running tests for %d matrix combinations\n", len(cells))
+}
+
+// testName returns the test function name of a test ID.
+func (r *Runner) testName(id string) string {
+	if tc, ok := r.testCases[id]; ok {
+		return tc.name
+	}
+	return id
+}
+
+// matrixEnvArgs returns the docker run arguments setting a test's matrix
+// environment variables.
+func (r *Runner) matrixEnvArgs(id string) []string {
+	tc, ok := r.testCases[id]
+	if !ok {
+		return nil
+	}
+	args := make([]string, 0, 2*len(tc.cell.env))
+	for _, kv := range tc.cell.env {
+		args = append(args, "-e", kv)
+	}
+	return args
+}
+
+// printMatrixSummary prints the results grouped by matrix cell.
+func (r *Runner) printMatrixSummary() {
+	if len(r.testCases) == 0 {
+		return
+	}
+
+	type counts struct{ passed, failed int }
+	results := make(map[string]*counts)
+	var labels []string
+	for _, id := range r.testsToRun {
+		label := r.testCases[id].cell.label
+		if _, ok := results[label]; !ok {
+			results[label] = &counts{}
+			labels = append(labels, label)
+		}
+	}
+	for _, id := range r.passedTests {
+		results[r.testCases[id].cell.label].passed++
+	}
+	for _, id := range r.failedTests {
+		results[r.testCases[id].cell.label].failed++
+	}
+
+	fmt.Printf("\n=== MATRIX\n")
+	for _, label := range labels {
+		c := results[label]
+		status := "PASS"
+		if c.failed > 0 {
+			status = "FAIL"
+		}
+		fmt.Printf("%s: %s (%d passed, %d failed)\n", r.status(status), label, c.passed, c.failed)
+	}
+}
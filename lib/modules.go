@@ -0,0 +1,64 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// findBuildDir returns the build context directory for the module containing
+// dir: the directory of the nearest go.work file above it that uses the
+// module, or else the module's own directory.
+func findBuildDir(dir string) (string, error) {
+	goModPath, err := findGoMod(dir)
+	if err != nil {
+		return "", err
+	}
+	moduleDir := filepath.Dir(goModPath)
+	for workDir := moduleDir; ; {
+		uses, err := goWorkUses(filepath.Join(workDir, "go.work"))
+		if err != nil {
+			return "", err
+		}
+		for _, use := range uses {
+			if filepath.Join(workDir, use) == moduleDir {
+				return workDir, nil
+			}
+		}
+		parent := filepath.Dir(workDir)
+		if parent == workDir {
+			return moduleDir, nil
+		}
+		workDir = parent
+	}
+}
+
+// goWorkUses returns the directories in the use directives of a go.work file,
+// or nil if it doesn't exist.
+func goWorkUses(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	var uses []string
+	inBlock := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		line = strings.TrimSpace(line)
+		switch {
+		case inBlock && line == ")":
+			inBlock = false
+		case inBlock && line != "":
+			uses = append(uses, strings.Trim(line, `"`))
+		case line == "use (":
+			inBlock = true
+		case strings.HasPrefix(line, "use "):
+			uses = append(uses, strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "use ")), `"`))
+		}
+	}
+	return uses, nil
+}
@@ -0,0 +1,90 @@
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// mountedBinaryPath is where the test binary is mounted in test containers
+// with MountBinary.
+const mountedBinaryPath = "/e2e.test"
+
+// buildTestBinary builds the test binary of the test directory on the host
+// for linux and the build platform's architecture, into the run's temporary
+// directory.
+func (r *Runner) buildTestBinary() error {
+	dir, err := r.makeTmpDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "e2e.test")
+
+	args := []string{"test", "-c", "-o", path}
+	if r.config.Race {
+		args = append(args, "-race")
+	}
+	if len(r.buildTags) > 0 {
+		args = append(args, "-tags", strings.Join(r.buildTags, ","))
+	}
+	args = append(args, ".")
+
+	fmt.Printf("--- INFO: Building test binary in %s...\n", r.config.TestDir)
+	start := time.Now()
+	cmd := exec.Command("go", args...)
+	cmd.Dir = r.config.TestDir
+	cmd.Env = r.dockerBuildEnv()
+	var output bytes.Buffer
+	if r.config.Verbosity > 0 {
+		cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+		cmd.Stderr = io.MultiWriter(os.Stderr, &output)
+	} else {
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+	}
+	if err := cmd.Run(); err != nil {
+		buildErr := newBuildError(output.String(), r.config.Verbosity > 0)
+		buildErr.what = "test binary"
+		return buildErr
+	}
+	fmt.Printf("--- OK: go test -c (%.2fs)\n", time.Since(start).Seconds())
+
+	r.binaryPath = path
+	return nil
+}
+
+// checkMountBinaryTests checks that the tests to run are in the package
+// of TestDir, as the test binary built by buildTestBinary contains only
+// that package, and a test from another package would pass without running.
+func (r *Runner) checkMountBinaryTests() error {
+	root, err := filepath.Abs(r.config.TestDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	for _, test := range r.testsToRun {
+		for _, dir := range r.testDirs[test] {
+			dir, err := filepath.Abs(dir)
+			if err != nil {
+				return fmt.Errorf("failed to get absolute path: %v", err)
+			}
+			if dir != root {
+				return fmt.Errorf("%s is in %s, but mount-binary only builds the tests in %s; exclude its directory with exclude-dirs or test-files", test, dir, root)
+			}
+		}
+	}
+	return nil
+}
+
+// mountBinaryArgs returns the docker run arguments mounting the test binary
+// and running it as the entrypoint, if MountBinary is enabled.
+func (r *Runner) mountBinaryArgs() []string {
+	if r.binaryPath == "" {
+		return nil
+	}
+	return []string{"-v", r.binaryPath + ":" + mountedBinaryPath + ":ro", "--entrypoint", mountedBinaryPath}
+}
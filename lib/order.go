@@ -0,0 +1,50 @@
+package e2e
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"time"
+)
+
+// Test orders supported by the Order option.
+const (
+	OrderSource = "source"
+	OrderAlpha  = "alpha"
+	OrderRandom = "random"
+)
+
+func validateOrder(order string) error {
+	switch order {
+	case "", OrderSource, OrderAlpha, OrderRandom:
+		return nil
+	default:
+		return fmt.Errorf("invalid order %q: must be one of %s, %s, %s", order, OrderSource, OrderAlpha, OrderRandom)
+	}
+}
+
+// shuffleArgs returns the -test.shuffle flag of Shuffle, or nil.
+func (r *Runner) shuffleArgs() []string {
+	if !r.config.Shuffle {
+		return nil
+	}
+	return []string{fmt.Sprintf("-test.shuffle=%d", r.seed)}
+}
+
+// orderTests reorders the tests in place according to the configured order.
+// For the random order, it returns the seed used.
+func orderTests(tests []string, order string, seed int64) int64 {
+	switch order {
+	case OrderAlpha:
+		sort.Strings(tests)
+	case OrderRandom:
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		rng := rand.New(rand.NewPCG(uint64(seed), 0))
+		rng.Shuffle(len(tests), func(i, j int) {
+			tests[i], tests[j] = tests[j], tests[i]
+		})
+	}
+	return seed
+}
@@ -0,0 +1,179 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+// statusColors maps test statuses to the color used to print them.
+var statusColors = map[string]string{
+	"PASS": colorGreen,
+	"FAIL": colorRed,
+	"STOP": colorYellow,
+	"SKIP": colorYellow,
+}
+
+// useColor reports whether output should be colorized: color is enabled
+// unless disabled by the option or the NO_COLOR environment variable, and
+// stdout is a terminal.
+func useColor(noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return stdoutIsTerminal()
+}
+
+// stdoutIsTerminal reports whether stdout is a terminal.
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// status returns the given status, colorized if color output is enabled.
+func (r *Runner) status(s string) string {
+	color, ok := statusColors[s]
+	if !r.color || !ok {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// resultLine returns the line printed when a test completes, in the compact
+// format with Compact.
+func (r *Runner) resultLine(status, test string, duration time.Duration) string {
+	if r.config.Compact {
+		return fmt.Sprintf("%s %s %.2fs\n", r.status(status), test, duration.Seconds())
+	}
+	return fmt.Sprintf("--- %s: %s (%.2fs)\n", r.status(status), test, duration.Seconds())
+}
+
+const defaultFailureOutputLines = 50
+
+// failureOutput returns the tail of a failed test's output to print, per
+// FailureOutputLines, with a note on where to find the rest.
+func (r *Runner) failureOutput(output string) string {
+	n := defaultFailureOutputLines
+	if r.config.FailureOutputLines != nil {
+		n = *r.config.FailureOutputLines
+	}
+	tail, dropped := tailLines(output, n)
+	if dropped == 0 {
+		return output
+	}
+	where := "run with -v to see it"
+	if r.config.BundlePath != "" {
+		where = "see " + r.config.BundlePath + " for all of it"
+	}
+	return fmt.Sprintf("... %d earlier lines of output not shown; %s ...\n%s", dropped, where, tail)
+}
+
+// tailLines returns the last n lines of s, and the number of lines dropped.
+// A trailing newline does not count as a line. n <= 0 returns all of s.
+func tailLines(s string, n int) (string, int) {
+	if n <= 0 {
+		return s, 0
+	}
+	i := len(strings.TrimSuffix(s, "\n"))
+	for lines := 0; lines < n; lines++ {
+		i = strings.LastIndexByte(s[:i], '\n')
+		if i < 0 {
+			return s, 0
+		}
+	}
+	return s[i+1:], strings.Count(s[:i], "\n") + 1
+}
+
+// testOutput is the output of a test: its stdout and stderr interleaved as
+// written, and each stream separately. Writes to the testOutput itself only
+// go to the interleaved output.
+type testOutput struct {
+	cappedBuffer
+	stdout cappedBuffer
+	stderr cappedBuffer
+}
+
+func newTestOutput(max int) *testOutput {
+	return &testOutput{
+		cappedBuffer: cappedBuffer{max: max},
+		stdout:       cappedBuffer{max: max},
+		stderr:       cappedBuffer{max: max},
+	}
+}
+
+// cappedBuffer is an io.Writer that keeps at most max bytes of what is
+// written to it: the first and last max/2 bytes, with the bytes in between
+// dropped. A max of 0 keeps everything. It is safe for concurrent use.
+type cappedBuffer struct {
+	max int
+
+	mu   sync.Mutex
+	head []byte
+	// tail grows to twice its cap before being trimmed, so that the bytes
+	// are not shifted on every write.
+	tail    []byte
+	dropped int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	if b.max <= 0 {
+		b.head = append(b.head, p...)
+		return n, nil
+	}
+
+	headMax := b.max / 2
+	if len(b.head) < headMax {
+		k := min(headMax-len(b.head), len(p))
+		b.head = append(b.head, p[:k]...)
+		p = p[k:]
+	}
+	tailMax := b.max - headMax
+	if len(p) > tailMax {
+		b.dropped += len(b.tail) + len(p) - tailMax
+		b.tail = append(b.tail[:0], p[len(p)-tailMax:]...)
+		return n, nil
+	}
+	b.tail = append(b.tail, p...)
+	if len(b.tail) > 2*tailMax {
+		drop := len(b.tail) - tailMax
+		b.dropped += drop
+		b.tail = append(b.tail[:0], b.tail[drop:]...)
+	}
+	return n, nil
+}
+
+func (b *cappedBuffer) WriteString(s string) (int, error) {
+	return b.Write([]byte(s))
+}
+
+// String returns the kept output, with a marker where bytes were dropped.
+func (b *cappedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tail, truncated := b.tail, b.dropped
+	if tailMax := b.max - b.max/2; b.max > 0 && len(tail) > tailMax {
+		truncated += len(tail) - tailMax
+		tail = tail[len(tail)-tailMax:]
+	}
+	if truncated == 0 {
+		return string(b.head) + string(tail)
+	}
+	return fmt.Sprintf("%s\n... truncated %d bytes ...\n%s", b.head, truncated, tail)
+}
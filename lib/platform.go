@@ -0,0 +1,67 @@
+package e2e
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultGOARCH is the architecture tests are built for when no build
+// platform is set.
+const defaultGOARCH = "amd64"
+
+// parsePlatform splits a docker platform of the form os/arch[/variant].
+func parsePlatform(platform string) (goos, goarch string, err error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid platform %q: expected os/arch[/variant]", platform)
+	}
+	if parts[0] != "linux" {
+		return "", "", fmt.Errorf("invalid platform %q: only linux is supported", platform)
+	}
+	return parts[0], parts[1], nil
+}
+
+// validatePlatforms checks the format of the build and run platforms.
+func validatePlatforms(build, run string) error {
+	for _, platform := range []string{build, run} {
+		if platform == "" {
+			continue
+		}
+		if _, _, err := parsePlatform(platform); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// goarch returns the architecture tests are built for.
+func (r *Runner) goarch() string {
+	if r.config.BuildPlatform == "" {
+		return defaultGOARCH
+	}
+	_, arch, _ := parsePlatform(r.config.BuildPlatform)
+	return arch
+}
+
+// cgoEnabled reports whether tests are built with cgo: with Race, which
+// requires it, or with CGO_ENABLED=1 in BuildEnv.
+func (r *Runner) cgoEnabled() bool {
+	return r.config.Race || r.config.BuildEnv["CGO_ENABLED"] == "1"
+}
+
+// platformMismatch returns a warning if the image is built for a different
+// architecture than it is run on, or "" if not.
+func (r *Runner) platformMismatch() string {
+	if r.config.RunPlatform == "" {
+		return ""
+	}
+	_, runArch, _ := parsePlatform(r.config.RunPlatform)
+	if runArch == r.goarch() {
+		return ""
+	}
+	build := r.config.BuildPlatform
+	if build == "" {
+		build = "linux/" + defaultGOARCH
+	}
+	return fmt.Sprintf("test binaries built for %s cannot run natively on %s; the image must be multi-platform or run under emulation", build, r.config.RunPlatform)
+}
@@ -0,0 +1,74 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// checkDockerDaemon verifies that docker is installed and its daemon is
+// reachable, so that Setup can fail early with a clear message instead of a
+// failed build.
+func (r *Runner) checkDockerDaemon() error {
+	cmd := exec.Command("docker", "info", "--format", "{{.ServerVersion}} ({{.OperatingSystem}})")
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+	}
+	output, err := cmd.CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%w: docker is not installed or not in PATH", ErrDaemonUnavailable)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: could not connect to the docker daemon; start Docker or check DOCKER_HOST\n%s", ErrDaemonUnavailable, strings.TrimSpace(string(output)))
+	}
+	if r.config.Verbosity > 0 {
+		fmt.Printf("--- INFO: Using docker %s\n", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// minMemoryPerTest is the daemon memory assumed to be needed per concurrent
+// test container when checking parallelism against docker's resources.
+const minMemoryPerTest = 256 << 20
+
+// dockerParallelismLimit returns the number of concurrent test containers a
+// daemon with ncpu CPUs and memTotal bytes of memory can run without being
+// oversubscribed.
+func dockerParallelismLimit(ncpu int, memTotal int64) int {
+	return max(min(ncpu, int(memTotal/minMemoryPerTest)), 1)
+}
+
+// checkDockerResources warns, or with CapParallelismToDocker lowers the
+// parallelism, when more tests would run in parallel than the docker daemon
+// has CPUs or memory for, as is common with Docker Desktop VMs that are
+// smaller than the host.
+func (r *Runner) checkDockerResources() {
+	if r.config.NoParallel {
+		return
+	}
+	cmd := exec.Command("docker", "info", "--format", "{{.NCPU}} {{.MemTotal}}")
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return
+	}
+	var ncpu int
+	var memTotal int64
+	if _, err := fmt.Sscan(string(output), &ncpu, &memTotal); err != nil || ncpu <= 0 || memTotal <= 0 {
+		return
+	}
+
+	limit := dockerParallelismLimit(ncpu, memTotal)
+	if r.parallelism <= limit {
+		return
+	}
+	if r.config.CapParallelismToDocker {
+		fmt.Printf("--- INFO: Lowering parallelism from %d to %d to fit the docker daemon's %d CPUs and %.1f GiB of memory\n", r.parallelism, limit, ncpu, float64(memTotal)/(1<<30))
+		r.parallelism = limit
+		return
+	}
+	fmt.Printf("--- INFO: Parallelism %d exceeds the docker daemon's %d CPUs and %.1f GiB of memory, which can cause timeouts; consider -p %d\n", r.parallelism, ncpu, float64(memTotal)/(1<<30), limit)
+}
@@ -0,0 +1,123 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Prune removes leftover resources from previous runs: the images built by
+// the runner, the test containers that were not removed, and cached run
+// state. Containers that are still running, e.g. of a run in progress, and
+// their images are kept. If dryRun is true, the resources are listed but not
+// removed.
+func Prune(dryRun bool, verbosity int) error {
+	images, err := dockerList(verbosity, "images",
+		"--filter", "reference="+containerBuildImagePrefix+"-*",
+		"--format", "{{.Repository}}:{{.Tag}}")
+	if err != nil {
+		return fmt.Errorf("failed to list images: %v", err)
+	}
+	containers, err := dockerList(verbosity, "ps", "--all",
+		"--filter", "name=^e2e-",
+		"--filter", "status=created",
+		"--filter", "status=exited",
+		"--filter", "status=dead",
+		"--format", "{{.Names}}")
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %v", err)
+	}
+	liveImages, err := dockerList(verbosity, "ps",
+		"--filter", "name=^e2e-",
+		"--format", "{{.Image}}")
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %v", err)
+	}
+	images = slices.DeleteFunc(images, func(img string) bool {
+		return slices.Contains(liveImages, img)
+	})
+
+	cache, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	cacheFiles, err := filepath.Glob(filepath.Join(cache, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list cache files: %v", err)
+	}
+
+	if len(images) == 0 && len(containers) == 0 && len(cacheFiles) == 0 {
+		fmt.Printf("--- INFO: Nothing to prune.\n")
+		return nil
+	}
+
+	verb := "Removing"
+	if dryRun {
+		verb = "Would remove"
+	}
+	for _, c := range containers {
+		fmt.Printf("--- INFO: %s container %s\n", verb, c)
+	}
+	for _, img := range images {
+		fmt.Printf("--- INFO: %s image %s\n", verb, img)
+	}
+	for _, f := range cacheFiles {
+		fmt.Printf("--- INFO: %s cache file %s\n", verb, f)
+	}
+	if dryRun {
+		return nil
+	}
+
+	// Remove containers first so that their images can be removed.
+	if len(containers) > 0 {
+		if err := dockerRun(verbosity, append([]string{"rm", "--force"}, containers...)...); err != nil {
+			return fmt.Errorf("failed to remove containers: %v", err)
+		}
+	}
+	if len(images) > 0 {
+		if err := dockerRun(verbosity, append([]string{"rmi", "--force"}, images...)...); err != nil {
+			return fmt.Errorf("failed to remove images: %v", err)
+		}
+	}
+	for _, f := range cacheFiles {
+		if err := os.Remove(f); err != nil {
+			return fmt.Errorf("failed to remove cache file: %v", err)
+		}
+	}
+	fmt.Printf("--- OK: pruned %d containers, %d images and %d cache files\n", len(containers), len(images), len(cacheFiles))
+	return nil
+}
+
+// dockerList runs a docker listing command and returns its non-empty output lines.
+func dockerList(verbosity int, args ...string) ([]string, error) {
+	cmd := exec.Command("docker", args...)
+	if verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// dockerRun runs a docker command, returning its combined output on failure.
+func dockerRun(verbosity int, args ...string) error {
+	cmd := exec.Command("docker", args...)
+	if verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v\n%s", err, output)
+	}
+	return nil
+}
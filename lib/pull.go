@@ -0,0 +1,70 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	pullAttempts       = 3
+	pullInitialBackoff = 2 * time.Second
+)
+
+// errPullFailed is wrapped by the error of a failed pull of the base image.
+var errPullFailed = errors.New("failed to pull image")
+
+// pullBaseImage pulls the configured base image, optionally from a registry
+// mirror, retrying with backoff on failure.
+func (r *Runner) pullBaseImage() error {
+	source := r.config.PullImage
+	if r.config.RegistryMirror != "" {
+		source = mirrorImage(r.config.RegistryMirror, r.config.PullImage)
+	}
+
+	fmt.Printf("--- INFO: Pulling base image %s...\n", source)
+	start := time.Now()
+	err := retryWithBackoff(pullAttempts, pullInitialBackoff, func(attempt int) (bool, error) {
+		cmd := exec.Command("docker", "pull", source)
+		if r.config.Verbosity > 1 {
+			fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+		}
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			if attempt < pullAttempts {
+				fmt.Printf("--- INFO: docker pull failed (attempt %d/%d), retrying...\n", attempt, pullAttempts)
+			}
+			return true, fmt.Errorf("%w %s\n%s", errPullFailed, source, output)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if source != r.config.PullImage {
+		cmd := exec.Command("docker", "tag", source, r.config.PullImage)
+		if r.config.Verbosity > 1 {
+			fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+		}
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to tag image %s as %s\n%s", source, r.config.PullImage, output)
+		}
+	}
+
+	fmt.Printf("--- OK: docker pull (%.2fs)\n", time.Since(start).Seconds())
+	return nil
+}
+
+// mirrorImage returns the name of a Docker Hub image in a registry mirror.
+// Official images such as alpine:3.19 are under library/ in the mirror, as
+// Docker Hub only resolves the short name itself.
+func mirrorImage(mirror, image string) string {
+	image = strings.TrimPrefix(image, "docker.io/")
+	if !strings.Contains(image, "/") {
+		image = "library/" + image
+	}
+	return strings.TrimSuffix(mirror, "/") + "/" + image
+}
@@ -0,0 +1,47 @@
+package e2e
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// imageRegistryHost returns the registry host of an image name, or "" if the
+// image is on Docker Hub. As in docker, the first path component is a host if
+// it contains a dot or colon or is localhost.
+func imageRegistryHost(image string) string {
+	first, _, ok := strings.Cut(image, "/")
+	if !ok {
+		return ""
+	}
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return first
+	}
+	return ""
+}
+
+// pushImage pushes the built image, retrying with backoff on failure.
+func (r *Runner) pushImage() error {
+	fmt.Printf("--- INFO: Pushing image %s...\n", r.containerBuildImage)
+	start := time.Now()
+	err := retryWithBackoff(pullAttempts, pullInitialBackoff, func(attempt int) (bool, error) {
+		cmd := exec.Command("docker", "push", r.containerBuildImage)
+		if r.config.Verbosity > 1 {
+			fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+		}
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			if attempt < pullAttempts {
+				fmt.Printf("--- INFO: docker push failed (attempt %d/%d), retrying...\n", attempt, pullAttempts)
+			}
+			return true, fmt.Errorf("failed to push image %s\n%s", r.containerBuildImage, output)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("--- OK: docker push (%.2fs)\n", time.Since(start).Seconds())
+	return nil
+}
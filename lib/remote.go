@@ -0,0 +1,55 @@
+package e2e
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// remoteDockerHost returns DOCKER_HOST if it points at a docker daemon on
+// another machine, where paths on this host cannot be bind-mounted.
+func remoteDockerHost() string {
+	host := os.Getenv("DOCKER_HOST")
+	u, err := url.Parse(host)
+	if err != nil || (u.Scheme != "tcp" && u.Scheme != "ssh") {
+		return ""
+	}
+	hostname := u.Hostname()
+	if hostname == "localhost" {
+		return ""
+	}
+	if ip := net.ParseIP(hostname); ip != nil && ip.IsLoopback() {
+		return ""
+	}
+	return host
+}
+
+// checkRemoteMounts returns an error if the docker daemon is remote and the
+// tests need host paths bind-mounted into their containers, which would
+// silently mount empty directories on the remote host instead. The build is
+// not affected, as the docker client uploads the build context.
+func (r *Runner) checkRemoteMounts() error {
+	host := remoteDockerHost()
+	if host == "" {
+		return nil
+	}
+	var mounts []string
+	for _, vol := range r.config.DataVolumes {
+		mounts = append(mounts, "data-volumes entry "+vol)
+	}
+	if r.config.MountBinary {
+		mounts = append(mounts, "mount-binary")
+	}
+	if r.config.AssetsMountPath != "" && r.testdataDir() != "" {
+		mounts = append(mounts, "testdata for assets-mount-path")
+	}
+	for _, test := range sortedKeys(r.testFixtures) {
+		mounts = append(mounts, "fixtures of "+test)
+	}
+	if len(mounts) == 0 {
+		return nil
+	}
+	return fmt.Errorf("DOCKER_HOST %s is a remote docker host, which cannot bind-mount paths on this host: %s; copy the files into the image in the Dockerfile instead", host, strings.Join(mounts, ", "))
+}
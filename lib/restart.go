@@ -0,0 +1,96 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const restartPollInterval = 500 * time.Millisecond
+
+// validateRestartPolicy checks that the restart policy is one docker accepts
+// and that lets a test finish. Policies that also restart containers that
+// exit successfully, always and unless-stopped, would never let a test end.
+func validateRestartPolicy(policy string) error {
+	name, count, hasCount := strings.Cut(policy, ":")
+	switch name {
+	case "", "no":
+		if !hasCount {
+			return nil
+		}
+	case "on-failure":
+		if n, err := strconv.Atoi(count); !hasCount || (err == nil && n > 0) {
+			return nil
+		}
+	case "always", "unless-stopped":
+		return fmt.Errorf("invalid restart policy %q: it would restart test containers after they pass; use on-failure[:max-retries]", policy)
+	}
+	return fmt.Errorf("invalid restart policy %q: expected no or on-failure[:max-retries]", policy)
+}
+
+// restartContainers reports whether test containers have a restart policy,
+// in which case they are not run with --rm, which docker does not allow
+// with one, and are removed after the test instead.
+func (r *Runner) restartContainers() bool {
+	return r.config.RestartPolicy != "" && r.config.RestartPolicy != "no"
+}
+
+// waitForRestarts waits, after docker run returned err for a container with
+// a restart policy, for the container to stop restarting, and returns the
+// error of its last run. docker run only reports the first exit of the
+// container, so the output of its last run is fetched with docker logs and
+// written to output; that of the runs in between is not.
+func (r *Runner) waitForRestarts(ctx context.Context, name string, err error, output io.Writer) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	for {
+		cmd := exec.CommandContext(ctx, "docker", "inspect", "--format", "{{.State.Status}} {{.State.ExitCode}} {{.RestartCount}} {{.State.StartedAt}}", name)
+		out, inspectErr := cmd.Output()
+		if inspectErr != nil {
+			return err
+		}
+		var status, startedAt string
+		var exitCode, restarts int
+		if _, scanErr := fmt.Sscan(string(out), &status, &exitCode, &restarts, &startedAt); scanErr != nil {
+			return err
+		}
+		if status == "exited" || status == "dead" {
+			if restarts == 0 {
+				return err
+			}
+			fmt.Fprintf(output, "\n--- INFO: Container restarted %d times, last exiting with code %d\n", restarts, exitCode)
+			r.writeLastRunLogs(name, startedAt, output)
+			if exitCode == 0 {
+				return nil
+			}
+			return fmt.Errorf("container exited with code %d after %d restarts", exitCode, restarts)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(restartPollInterval):
+		}
+	}
+}
+
+// writeLastRunLogs writes the output of the last run of a restarted
+// container, started at startedAt, to output.
+func (r *Runner) writeLastRunLogs(name, startedAt string, output io.Writer) {
+	cmd := exec.Command("docker", "logs", "--since", startedAt, name)
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+	}
+	logs, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(output, "--- INFO: Failed to get the output of the last run: %v\n", err)
+		return
+	}
+	fmt.Fprintf(output, "--- INFO: Output of the last run:\n%s", logs)
+}
@@ -0,0 +1,80 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+// retryWithBackoff calls fn up to attempts times, doubling the delay between
+// attempts starting from backoff. It stops early if fn succeeds or reports
+// that its error is not retryable, and returns the last error. The attempt
+// number passed to fn starts at 1.
+func retryWithBackoff(attempts int, backoff time.Duration, fn func(attempt int) (retry bool, err error)) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		var retry bool
+		if retry, err = fn(attempt); err == nil || !retry {
+			return err
+		}
+		if attempt < attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+// RunWithRetry runs Setup, RunTests and Cleanup, retrying the whole run up to
+// attempts times, doubling the delay between attempts starting from backoff,
+// when Setup fails with an infrastructure error: ErrDaemonUnavailable, e.g.
+// during a daemon restart, a failed pull of PullImage, or an ErrBuildFailed
+// caused by a transient network error, e.g. on a registry outage.
+//
+// Test failures are never retried: the tests ran, and retrying would hide
+// their result. Other Setup errors, such as ErrDockerfileNotFound or a build
+// that fails to compile the code, are not retried either as they would fail
+// again.
+func (r *Runner) RunWithRetry(attempts int, backoff time.Duration) error {
+	return retryWithBackoff(attempts, backoff, func(attempt int) (bool, error) {
+		defer r.Cleanup()
+		if err := r.Setup(); err != nil {
+			retry := isInfraError(err)
+			if retry && attempt < attempts {
+				fmt.Printf("--- INFO: Setup failed (attempt %d/%d), retrying: %v\n", attempt, attempts, err)
+			}
+			return retry, err
+		}
+		return false, r.RunTests()
+	})
+}
+
+// isInfraError reports whether a Setup error is caused by the docker
+// infrastructure rather than by the config or the tests.
+func isInfraError(err error) bool {
+	if errors.Is(err, ErrDaemonUnavailable) || errors.Is(err, errPullFailed) {
+		return true
+	}
+	var buildErr *BuildError
+	if errors.As(err, &buildErr) {
+		return len(buildErr.Diagnostics) == 0 && isRetryableBuildOutput(buildErr.Output)
+	}
+	return false
+}
+
+// retryTest reports whether a test that failed with err on the given attempt
+// should be retried: if it has retries left, the run was not cancelled, and
+// with RetryExitCodes, docker exited with one of them.
+func (r *Runner) retryTest(ctx context.Context, err error, attempt int) bool {
+	if attempt > r.config.TestRetries || ctx.Err() != nil {
+		return false
+	}
+	if len(r.config.RetryExitCodes) == 0 {
+		return true
+	}
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && slices.Contains(r.config.RetryExitCodes, exitErr.ExitCode())
+}
@@ -3,55 +3,660 @@ package e2e
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"go/ast"
-	"go/parser"
-	"go/token"
 	"io"
-	"math/rand"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 )
 
 const (
 	containerBuildImagePrefix = "e2e-test-runner"
+	buildInitialBackoff       = 2 * time.Second
+	defaultBuildTimeout       = 15 * time.Minute
 )
 
 type RunnerConfig struct {
-	TestDir       string   `yaml:"test-dir"`
-	Dockerfile    string   `yaml:"dockerfile"`
+	TestDir    string `yaml:"test-dir"`
+	Dockerfile string `yaml:"dockerfile"`
+
+	// DockerfileContent is an inline Dockerfile used instead of Dockerfile,
+	// so that simple suites need only a config file. It is built with the
+	// same build context as a Dockerfile would be.
+	DockerfileContent string `yaml:"dockerfile-content"`
+
+	// DockerfileCommand is a shell command run on the host in TestDir
+	// whose output is used as the Dockerfile, like DockerfileContent, for
+	// Dockerfiles generated from templates or the dependency set.
+	DockerfileCommand string `yaml:"dockerfile-command"`
+
+	// GoModDir overrides the docker build context directory, which is
+	// otherwise the directory of the go.work file using the module of
+	// TestDir, or of the module's go.mod file. It must contain a go.mod or
+	// go.work file.
+	GoModDir string `yaml:"go-mod-dir"`
+
+	// DockerRunArgs are extra docker run arguments. Each entry is split into
+	// arguments like a shell would, so quoted values may contain spaces, e.g.
+	// `-e MSG="hello world"`.
 	DockerRunArgs []string `yaml:"docker-run-args"`
 
+	// BuildPlatform and RunPlatform are passed to docker build and docker
+	// run as --platform, in os/arch[/variant] form, e.g. to build under
+	// emulation but run natively. The tests are built for BuildPlatform's
+	// architecture (default amd64); a warning is printed if RunPlatform's
+	// architecture differs.
+	BuildPlatform string `yaml:"build-platform"`
+	RunPlatform   string `yaml:"run-platform"`
+
+	// ImageName is the name the test image is built as, e.g.
+	// registry.example.com/team/e2e:latest. It defaults to a unique
+	// e2e-test-runner-<id>:dev name per run. Images with a custom name are
+	// not removed by Prune, only by CleanupPolicy.
+	ImageName string `yaml:"image-name"`
+
+	// PushImage pushes the built image to the registry in ImageName, which
+	// must include a registry host.
+	PushImage bool `yaml:"push-image"`
+
+	// BuildTarget is the Dockerfile stage to build, passed as --target, so a
+	// multi-stage Dockerfile can have a dedicated test stage.
+	BuildTarget string `yaml:"build-target"`
+
+	// DockerfileGroups run the tests matching each group's test pattern in
+	// an image built from the group's Dockerfile, for suites whose tests need
+	// different runtime images. Tests matching no group run in the image
+	// built from Dockerfile.
+	DockerfileGroups []DockerfileGroup `yaml:"dockerfile-groups"`
+
+	// PullImage is a base image that is pulled before the build and passed to
+	// it as the BASE_IMAGE build arg. If RegistryMirror, a mirror of Docker
+	// Hub, is set, the image is pulled from the mirror, under library/ for
+	// official images, and retagged as PullImage so that Dockerfiles
+	// referencing it resolve locally.
+	PullImage      string `yaml:"pull-image"`
+	RegistryMirror string `yaml:"registry-mirror"`
+
+	// DataVolumes are bind mounts in host:container[:ro|rw] form that are
+	// mounted into every test container, e.g. for shared read-only datasets.
+	DataVolumes []string `yaml:"data-volumes"`
+
+	// AssetsMountPath is the directory in the container the test binary
+	// runs in, with the testdata directory next to the config file mounted
+	// read-only under it, so tests that read ./testdata work as with go
+	// test. It is passed to the build as the ASSETS_PATH build arg for
+	// Dockerfiles that copy other assets there.
+	AssetsMountPath string `yaml:"assets-mount-path"`
+
+	// RestartPolicy is passed to docker run as --restart, e.g. on-failure:3,
+	// so that a test binary crashing transiently is restarted. Containers
+	// are then not run with --rm but removed after the test, which passes
+	// if the container's last run does. The output of the first and the last
+	// run is captured.
+	RestartPolicy string `yaml:"restart-policy"`
+
+	// AddHosts are hostname:ip entries added to each test container's
+	// /etc/hosts with --add-host. The IP may also be docker's host-gateway.
+	AddHosts []string `yaml:"add-hosts"`
+
+	// DNS are the IP addresses of DNS servers test containers use instead
+	// of the docker daemon's, passed as --dns, e.g. to resolve internal
+	// hostnames, and DNSSearch are search domains passed as --dns-search.
+	DNS       []string `yaml:"dns"`
+	DNSSearch []string `yaml:"dns-search"`
+
+	// Entrypoint overrides the image's ENTRYPOINT when running tests. The
+	// test flags are passed to it as arguments.
+	Entrypoint string `yaml:"entrypoint"`
+
+	// ExecInto is the name or ID of a running container to run the tests in
+	// with docker exec, instead of building an image and running a container
+	// per test, e.g. to debug in a long-lived dev environment. Entrypoint is
+	// required and is the path of the test binary in the container; options
+	// for building the image or creating containers do not apply.
+	ExecInto string `yaml:"exec-into"`
+
+	// SharedContainer starts one container from the test image in Setup and
+	// runs each test in it with docker exec, removing it in Cleanup, which
+	// avoids the cost of a container per test for suites of many fast tests.
+	// Tests share the container's filesystem and processes, so it requires
+	// NoParallel, and per-test fixtures are not mounted. A test killed for a
+	// timeout or cancellation restarts the container, so that it does not
+	// keep running. The image must have sleep, which keeps the container
+	// running, and the test binary is the Entrypoint or the image's
+	// entrypoint.
+	SharedContainer bool `yaml:"shared-container"`
+
+	// MountBinary builds the test binary on the host with go test -c and
+	// bind-mounts it into each test container as the entrypoint, instead of
+	// relying on the image to contain it. Combined with a Dockerfile that
+	// only installs dependencies, the image build is then fully cached and
+	// only the test binary is rebuilt between runs. It cannot be combined
+	// with Entrypoint. The binary is of the package in TestDir only, so
+	// Setup fails if tests are found in other packages.
+	MountBinary bool `yaml:"mount-binary"`
+
+	// User runs test containers as the given user, in uid[:gid] or
+	// name[:group] form. Files in DataVolumes must be readable, and writable
+	// if needed, by that user.
+	User string `yaml:"user"`
+
+	// IdleTimeout fails a test, stopping its container, if it produces no
+	// output for the duration, which usually means it is hung. Unlike a total
+	// timeout it lets long-running tests proceed as long as they make
+	// progress. Tests are not run with -test.v unless verbose, so they may
+	// need to log progress for this to be useful.
+	IdleTimeout time.Duration `yaml:"idle-timeout"`
+
+	// TestRetries is the number of times a failed test is re-run before it
+	// is reported as failed. RetryExitCodes restricts retries to failures
+	// where docker exited with one of the codes, e.g. 125 for docker errors
+	// or 137 for killed containers, so that assertion failures (exit code 1)
+	// are not retried.
+	TestRetries    int   `yaml:"test-retries"`
+	RetryExitCodes []int `yaml:"retry-exit-codes"`
+
+	// TestTimeout fails a test, stopping its container, if it runs for
+	// longer. A "//go:e2e timeout: <duration>" annotation above a test
+	// function overrides it for that test. Zero means no timeout.
+	TestTimeout time.Duration `yaml:"test-timeout"`
+
+	// MaxTestDuration fails a test that passes but takes longer, to keep
+	// slow tests from creeping in. Unlike TestTimeout, the test is not
+	// stopped. Zero means no limit.
+	MaxTestDuration time.Duration `yaml:"max-test-duration"`
+
+	// Containers of cancelled tests, e.g. on fail-fast, are killed and
+	// removed. StopSignal and StopTimeout make them stop with docker stop
+	// instead, sending StopSignal (default SIGTERM) and waiting up to
+	// StopTimeout (default 10s) for the test binary to exit before the
+	// container is removed.
+	StopSignal  string        `yaml:"stop-signal"`
+	StopTimeout time.Duration `yaml:"stop-timeout"`
+
+	// Matrix runs every test once per combination of the given environment
+	// variable values, e.g. {"PG_VERSION": ["13", "14"]}. Each run is named
+	// "TestName [PG_VERSION=13]" and results are summarized per combination.
+	Matrix map[string][]string `yaml:"matrix"`
+
+	// BeforeEachCommand and AfterEachCommand are shell commands run on the
+	// host, in TestDir, before and after each test, e.g. to reset shared
+	// external state. The test name is in the E2E_TEST environment variable.
+	// A failing command fails the test. Commands never run concurrently, but
+	// with parallel tests they can run while other tests are running, so they
+	// are usually only sensible with NoParallel.
+	BeforeEachCommand string `yaml:"before-each-command"`
+	AfterEachCommand  string `yaml:"after-each-command"`
+
+	// SetupCommand is a shell command run once in Setup, after WaitFor,
+	// e.g. to run migrations or seed fixtures, and TeardownCommand is run
+	// once in Cleanup if Setup got as far as the setup command. They run on
+	// the host in TestDir or, with SetupImage, with sh -c in a throwaway
+	// container of that image joined to the --network in DockerRunArgs.
+	SetupCommand    string `yaml:"setup-command"`
+	TeardownCommand string `yaml:"teardown-command"`
+	SetupImage      string `yaml:"setup-image"`
+
+	// RunID identifies the run in the E2E_RUN_ID environment variable and
+	// e2e.run label of every test container, e.g. a CI job ID, so that
+	// their logs can be correlated with the run. A random ID is generated
+	// by Setup if it is unset.
+	RunID string `yaml:"run-id"`
+
+	// Labels are added to every test container, along with the built-in
+	// e2e.test (test name) and e2e.run (run ID) labels.
+	Labels map[string]string `yaml:"labels"`
+
+	// ImageLabels are added to the built image, along with the built-in
+	// e2e.built-at (build time) label.
+	ImageLabels map[string]string `yaml:"image-labels"`
+
+	// WaitFor lists dependencies that must be ready before tests run, as
+	// host:port TCP endpoints or container:<name> entries for containers
+	// whose health check must report healthy. They are polled for up to
+	// WaitForTimeout (default 60s). Tests can require further dependencies
+	// with a requires annotation, which are only waited for if they run.
+	WaitFor        []string      `yaml:"wait-for"`
+	WaitForTimeout time.Duration `yaml:"wait-for-timeout"`
+
+	// CollectServiceLogs appends the logs of the container:<name> services in
+	// WaitFor, from the failing test's time window, to its output.
+	CollectServiceLogs bool `yaml:"collect-service-logs"`
+
+	// BuildEnv sets environment variables for the docker build, overriding the
+	// inherited host environment. Go module settings such as GOFLAGS and
+	// GOPROXY are also passed to the build as build args. GOOS, GOARCH and
+	// CGO_ENABLED are always forced to linux, the BuildPlatform architecture
+	// (default amd64) and 0, unless Race is set or BuildEnv sets CGO_ENABLED
+	// to 1. Test discovery evaluates //go:build lines for the same platform.
+	BuildEnv map[string]string `yaml:"build-env"`
+
+	// Race builds the tests with the race detector. The docker build is passed
+	// the RACE=-race and CGO_ENABLED=1 build args, which the Dockerfile must
+	// forward to go test -c; the builder needs a C toolchain and the test
+	// image a compatible libc. The race detector slows tests down and uses
+	// much more memory, so Parallelism is halved.
+	Race bool `yaml:"race"`
+
+	// BuildSecrets are BuildKit secrets for the docker build, mapping secret
+	// IDs to host files, passed as --secret id=<id>,src=<path>. Dockerfiles
+	// use them with RUN --mount=type=secret,id=<id>, so that e.g. registry
+	// tokens don't end up in image layers. BuildKit is enabled when set.
+	BuildSecrets map[string]string `yaml:"build-secrets"`
+
+	// BuildRetries is the number of times to retry the docker build when it
+	// fails with a transient network error.
+	BuildRetries int `yaml:"build-retries"`
+
+	// BuildTags are the build tags the tests are built with. They are passed
+	// to the docker build as the BUILD_TAGS build arg, comma-separated, and
+	// test files whose //go:build constraints they don't satisfy are not
+	// discovered. With AutoBuildTags, tags referenced by the test files'
+	// //go:build lines are added automatically; see resolveBuildTags.
+	BuildTags     []string `yaml:"build-tags"`
+	AutoBuildTags bool     `yaml:"auto-build-tags"`
+
+	// BuildTimeout is the maximum duration of each docker build attempt
+	// (default 15m).
+	BuildTimeout time.Duration `yaml:"build-timeout"`
+
+	// TmpDir is the directory temporary files such as the test binary of
+	// MountBinary and an inline Dockerfile are written to, e.g. a large
+	// workspace volume on CI runners with a small /tmp. It defaults to the
+	// OS temp directory. Setup checks that it is writable.
+	TmpDir string `yaml:"tmp-dir"`
+
+	// KeepTmpDir keeps the run's temporary directory, see Runner.TmpDir, on
+	// Cleanup and prints its path, to inspect the generated files after a
+	// failed build.
+	KeepTmpDir bool `yaml:"keep-tmp-dir"`
+
+	// BuildHeartbeat is the interval at which a line with the elapsed time
+	// is printed while the image builds, so that a long build without
+	// verbose output does not look hung (0 disables it).
+	BuildHeartbeat time.Duration `yaml:"build-heartbeat"`
+
+	// FailFast stops the run on the first failing test. It defaults to true
+	// when unset.
+	FailFast *bool `yaml:"fail-fast"`
+
+	// Deprecated: NoFastFail is an alias for FailFast=false and will be removed
+	// in a future release. FailFast takes precedence when both are set.
+	NoFastFail bool `yaml:"no-fast-fail"`
+
+	// TTY allocates a pseudo-TTY for test containers with --tty. It defaults
+	// to whether stdout is a terminal, as without one docker run can garble
+	// output or fail with "the input device is not a TTY".
+	TTY *bool `yaml:"tty"`
+
+	// MaxOutputBytes caps the output kept in memory for each test, which is
+	// printed when it fails, so a test writing excessive output cannot
+	// exhaust memory. The first and last halves are kept, with a truncation
+	// marker in between. 0 means no limit.
+	MaxOutputBytes int `yaml:"max-output-bytes"`
+
+	// FailureOutputLines is the number of trailing lines of a failed test's
+	// output printed without -v. It defaults to 50 when unset; 0 prints all
+	// of it.
+	FailureOutputLines *int `yaml:"failure-output-lines"`
+
+	// DedupeFailures prints the output of failed tests once per distinct
+	// failure in the summary, with the tests that failed with it, instead
+	// of as each test fails. Failures are the same if their output is, with
+	// test names, timestamps, durations and addresses ignored.
+	DedupeFailures bool `yaml:"dedupe-failures"`
+
+	// NoColor disables colorized output. Color is also disabled when the
+	// NO_COLOR environment variable is set or stdout is not a terminal.
+	NoColor bool `yaml:"no-color"`
+
+	// Compact prints a single "PASS TestName 1.23s" line per test when it
+	// completes, instead of a RUN line when it starts and a result line
+	// when it completes, to keep CI logs of large suites short. A failing
+	// test's output is still printed after its line.
+	Compact bool `yaml:"compact"`
+
 	Verbosity   int    `yaml:"verbosity"`
-	NoFastFail  bool   `yaml:"no-fast-fail"`
 	NoParallel  bool   `yaml:"no-parallel"`
 	Parallelism int    `yaml:"parallelism"`
 	TestPattern string `yaml:"test-pattern"`
+
+	// TestFlagsFile is a file of test binary flags, e.g. -test.count=1,
+	// passed to each test after -test.run and -test.v. Lines starting with
+	// # are comments.
+	TestFlagsFile string `yaml:"test-flags-file"`
+
+	// TimeBudget stops starting tests once the run has taken this long,
+	// letting running tests finish and reporting the rest as skipped. Zero
+	// means no budget.
+	TimeBudget time.Duration `yaml:"time-budget"`
+
+	// CapParallelismToDocker lowers Parallelism to what the docker daemon
+	// has CPUs and memory for, rather than only warning when it is exceeded.
+	CapParallelismToDocker bool `yaml:"cap-parallelism-to-docker"`
+
+	// TestFiles restricts test discovery to these _test.go files, relative
+	// to TestDir, instead of walking all of TestDir. TestPattern and
+	// SkipPattern still apply to the tests in them.
+	TestFiles []string `yaml:"test-files"`
+
+	// ExcludeDirs are directories skipped when walking TestDir for tests,
+	// e.g. vendor or generated mocks. Each is a glob matched against a
+	// directory's path relative to TestDir and its name, or a path prefix.
+	ExcludeDirs []string `yaml:"exclude-dirs"`
+
+	// ReportSkips reports tests that call t.Skip as SKIP rather than PASS,
+	// so that tests skipped by environment checks are noticed. Tests are run
+	// with -test.v to detect skips.
+	ReportSkips bool `yaml:"report-skips"`
+
+	// SkipPattern is a regexp; tests whose names match it are not run, even
+	// if they match TestPattern.
+	SkipPattern string `yaml:"skip-pattern"`
+
+	// ChangedSince is a git ref; only tests in packages with files changed
+	// since the ref, or with untracked files that are not ignored, are run.
+	// All tests are run if git is not available or TestDir is not in a git
+	// repository; an unknown ref is an error.
+	ChangedSince string `yaml:"changed-since"`
+
+	// FailOnNoTests makes Setup fail with ErrNoTests when no tests in TestDir
+	// match the filters, rather than running nothing successfully.
+	FailOnNoTests bool `yaml:"fail-on-no-tests"`
+
+	// RerunFailed runs only the tests that failed or did not complete in the
+	// previous run of the same test directory.
+	RerunFailed bool `yaml:"rerun-failed"`
+
+	// SinceLastPass skips tests that passed in the previous run of the same
+	// test directory and whose package sources, including the packages of
+	// the module it imports and go.mod and go.sum, have not changed since.
+	SinceLastPass bool `yaml:"since-last-pass"`
+
+	// NoDiscoveryCache disables caching the test functions and annotations
+	// of each test file, which otherwise saves parsing the test files that
+	// have not changed since the last run in the same test directory.
+	NoDiscoveryCache bool `yaml:"no-discovery-cache"`
+
+	// CleanupPolicy is when Cleanup removes the image built by Setup:
+	// always, on-success (keeping the image of a failed suite for
+	// debugging), or never (the default, leaving images to go-e2e prune).
+	// It applies to images with a custom ImageName too.
+	CleanupPolicy string `yaml:"cleanup-policy"`
+
+	// Order is the order tests are dispatched in: source (discovery order, the
+	// default), alpha (sorted by name) or random (shuffled using Seed, or a
+	// logged time-based seed if Seed is 0).
+	Order string `yaml:"order"`
+	Seed  int64  `yaml:"seed"`
+
+	// Shuffle passes -test.shuffle=<seed> to each test binary invocation,
+	// shuffling the order of the tests it runs, with Seed or a logged
+	// time-based seed if Seed is 0. The seed is repeated in the summary so
+	// the order can be replayed. Each invocation runs one test, so this only
+	// has an effect when RunPatternTemplate matches several.
+	Shuffle bool `yaml:"shuffle"`
+
+	// ExitCodePolicy decides whether failed tests fail the run when used from
+	// the CLI; see ParseExitCodePolicy. The default, any-failure, fails the run
+	// if any test failed.
+	ExitCodePolicy string `yaml:"exit-code-policy"`
+
+	// StrictMode fails the run unless every test selected to run passed,
+	// e.g. for release gates: tests that were skipped with ReportSkips, by
+	// TimeBudget or by a skip-unless-env annotation, that did not complete
+	// or that were not run also fail it. RunTests then returns a
+	// *StrictModeError listing them, which ExitCodePolicy does not apply to.
+	// Tests excluded by SkipPattern are not counted.
+	StrictMode bool `yaml:"strict-mode"`
+
+	// SkipDockerCheck skips the checks in Setup that the docker daemon is
+	// reachable and has the resources for Parallelism, for setups where
+	// docker info is unavailable.
+	SkipDockerCheck bool `yaml:"skip-docker-check"`
+
+	// BundlePath is a .tar.gz file that the config, the command line and
+	// output of each test, and a JSON summary of the results are written to
+	// after each run, for sharing a run with others. Build environment values,
+	// environment variables passed to docker run and all of the webhook URL
+	// but its scheme and host are redacted.
+	BundlePath string `yaml:"bundle-path"`
+
+	// TimingsExportPath is a file that the per-test durations are written to
+	// after each run, as a JSON object mapping test names to seconds.
+	TimingsExportPath string `yaml:"timings-export-path"`
+
+	// WebhookURL is POSTed a JSON object with the run ID, status (PASS or
+	// FAIL), result counts, duration in seconds and failed test names after
+	// each run, e.g. for a chat notification. A failing webhook is reported
+	// but does not fail the run.
+	WebhookURL string `yaml:"webhook-url"`
+
+	// OnEvent, if set, is called as the run starts, as each test starts and
+	// finishes, and when the run is done, for embedders that want structured
+	// progress rather than parsing stdout. Events are delivered in order from
+	// a separate goroutine, so a slow callback does not delay the tests, and
+	// RunTests returns once all events of the run have been delivered.
+	OnEvent func(Event) `yaml:"-"`
+
+	// ContainerLimiter, if set, bounds the number of test containers running
+	// at once across all runners sharing it, e.g. the suites of one process.
+	ContainerLimiter *ContainerLimiter `yaml:"-"`
+
+	// SlowestN is the number of slowest tests listed in the summary. It
+	// defaults to 10 when unset; 0 disables the list.
+	SlowestN *int `yaml:"slowest-n"`
+
+	// SummaryTemplate is a text/template rendered with the run's Results in
+	// place of the built-in summary, or the name of a built-in template:
+	// markdown or github-actions.
+	SummaryTemplate string `yaml:"summary-template"`
+
+	// RunPatternTemplate is a text/template for the -test.run pattern that
+	// runs each test, rendered with the test's .Name, e.g.
+	// "^{{.Name}}$/^Smoke" to run only its Smoke subtests. It defaults to
+	// "^{{.Name}}$". Test names need no escaping, but the rest of the
+	// template is a regexp, which should not match other tests.
+	RunPatternTemplate string `yaml:"run-pattern-template"`
 }
 
+// userPattern matches docker --user values: uid[:gid] or name[:group].
+var userPattern = regexp.MustCompile(`^([0-9]+|[a-zA-Z_][a-zA-Z0-9_.-]*)(:([0-9]+|[a-zA-Z_][a-zA-Z0-9_.-]*))?$`)
+
+// runIDPattern matches run IDs, which are used in container names.
+var runIDPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
 type Runner struct {
 	config RunnerConfig
 
 	containerBuildImage string
+	groupImages         []string
+	groupPatterns       []*regexp.Regexp
+	buildDir            string
+	buildTags           []string
+	runID               string
+	runArgs             []string
+	testFlags           []string
+	tmpDir              string
+	sharedContainer     string
+	execContainer       string
+	execBinary          string
+	binaryPath          string
+	skipPattern         *regexp.Regexp
+	summaryTemplate     *template.Template
+	runPatternTemplate  *template.Template
+	failFast            bool
+	color               bool
+	tty                 bool
+	passed              bool
+	// stopped is whether the caller's context was done before all tests
+	// finished.
+	stopped         bool
+	setupCommandRan bool
+
+	hookMu sync.Mutex
 
 	mu              sync.Mutex
 	failedTests     []string
+	cancelledTests  []string
 	passedTests     []string
 	incompleteTests []string
-	testTimings     map[string]time.Duration
-	testsToRun      []string
+	skippedTests    []string
+	// selfSkippedTests are the tests that called t.Skip, with ReportSkips.
+	selfSkippedTests []string
+	// budgetSkippedTests are the tests not started because the run exceeded
+	// TimeBudget.
+	budgetSkippedTests []string
+	budgetDeadline     time.Time
+	// envSkippedTests are the tests not run because environment variables
+	// their skip-unless-env annotation names are not set.
+	envSkippedTests []string
+	// tooSlowTests are the tests that failed for exceeding MaxTestDuration.
+	tooSlowTests []string
+	// setupStart is when Setup started, and buildDuration how long it took
+	// to prepare the test image.
+	setupStart    time.Time
+	buildDuration time.Duration
+	// parallelism is the Parallelism of the run, as lowered by Setup for
+	// Race and CapParallelismToDocker, leaving the config unchanged.
+	parallelism int
+	// seed is the seed of the random test order and of Shuffle.
+	seed             int64
+	testTimings      map[string]time.Duration
+	suiteDuration    time.Duration
+	testsToRun       []string
+	testFixtures     map[string]string
+	testTimeouts     map[string]time.Duration
+	testHealthChecks map[string]string
+	testWeights      map[string]int
+	testRequires     map[string][]string
+	testRequiredEnv  map[string][]string
+	// events queues the events of the current run for OnEvent.
+	events *eventQueue
+	// containerNames are the names given to test containers in this run.
+	containerNames map[string]bool
+	// testStreams are the separately captured stdout and stderr of each
+	// test that ran.
+	testStreams   map[string]testStreams
+	testDirs      map[string][]string
+	testCases     map[string]testCase
+	bundleRecords map[string]bundleRecord
 }
 
 func NewRunner(config RunnerConfig) (*Runner, error) {
 
 	// Check required options.
-	if config.Dockerfile == "" {
-		return nil, fmt.Errorf("dockerfile is required")
+	if config.ExecInto != "" {
+		if config.Entrypoint == "" {
+			return nil, fmt.Errorf("entrypoint is required with exec-into, as the path of the test binary in the container")
+		}
+	} else if sources := countSet(config.Dockerfile, config.DockerfileContent, config.DockerfileCommand); sources == 0 {
+		return nil, fmt.Errorf("dockerfile, dockerfile-content or dockerfile-command is required")
+	} else if sources > 1 {
+		return nil, fmt.Errorf("only one of dockerfile, dockerfile-content and dockerfile-command can be set")
+	}
+
+	if err := validateOrder(config.Order); err != nil {
+		return nil, err
+	}
+	if err := validateCleanupPolicy(config.CleanupPolicy); err != nil {
+		return nil, err
+	}
+	if len(config.RetryExitCodes) > 0 && config.TestRetries <= 0 {
+		return nil, fmt.Errorf("retry-exit-codes requires test-retries")
+	}
+	if _, err := ParseExitCodePolicy(config.ExitCodePolicy); err != nil {
+		return nil, err
+	}
+	if err := validateWaitFor(config.WaitFor); err != nil {
+		return nil, err
+	}
+	if err := validateMatrix(config.Matrix); err != nil {
+		return nil, err
+	}
+	if err := validateAddHosts(config.AddHosts); err != nil {
+		return nil, err
+	}
+	if err := validateDNS(config.DNS, config.DNSSearch); err != nil {
+		return nil, err
+	}
+	if err := validatePlatforms(config.BuildPlatform, config.RunPlatform); err != nil {
+		return nil, err
+	}
+	if config.PushImage && imageRegistryHost(config.ImageName) == "" {
+		return nil, fmt.Errorf("push-image requires an image-name with a registry host")
+	}
+	if config.SharedContainer && (!config.NoParallel || config.ExecInto != "") {
+		return nil, fmt.Errorf("shared-container requires no-parallel and cannot be combined with exec-into")
+	}
+	if config.MountBinary && config.Entrypoint != "" {
+		return nil, fmt.Errorf("mount-binary and entrypoint cannot both be set")
+	}
+	if err := validateExcludeDirs(config.ExcludeDirs); err != nil {
+		return nil, err
+	}
+	if err := validateRestartPolicy(config.RestartPolicy); err != nil {
+		return nil, err
+	}
+	if err := validateAssetsMountPath(config.AssetsMountPath); err != nil {
+		return nil, err
+	}
+	if err := validateWebhookURL(config.WebhookURL); err != nil {
+		return nil, err
+	}
+	if config.RunID != "" && !runIDPattern.MatchString(config.RunID) {
+		return nil, fmt.Errorf("invalid run id %q: expected letters, digits, '_', '.' and '-'", config.RunID)
+	}
+	if config.User != "" && !userPattern.MatchString(config.User) {
+		return nil, fmt.Errorf("invalid user %q: expected uid[:gid] or name[:group]", config.User)
+	}
+	groupPatterns, err := compileDockerfileGroups(config)
+	if err != nil {
+		return nil, err
+	}
+	var runArgs []string
+	for _, arg := range config.DockerRunArgs {
+		words, err := splitShellWords(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid docker run args: %v", err)
+		}
+		runArgs = append(runArgs, words...)
+	}
+	var skipPattern *regexp.Regexp
+	if config.SkipPattern != "" {
+		var err error
+		skipPattern, err = regexp.Compile(config.SkipPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid skip pattern: %v", err)
+		}
+	}
+	var summaryTemplate *template.Template
+	if config.SummaryTemplate != "" {
+		var err error
+		summaryTemplate, err = parseSummaryTemplate(config.SummaryTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var runPatternTemplate *template.Template
+	if config.RunPatternTemplate != "" {
+		var err error
+		runPatternTemplate, err = parseRunPattern(config.RunPatternTemplate)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Set option defaults.
@@ -59,26 +664,215 @@ func NewRunner(config RunnerConfig) (*Runner, error) {
 		config.TestDir = "."
 	}
 
+	failFast := !config.NoFastFail
+	if config.FailFast != nil {
+		failFast = *config.FailFast
+	}
+
+	tty := stdoutIsTerminal()
+	if config.TTY != nil {
+		tty = *config.TTY
+	}
+
 	return &Runner{
-		config: config,
+		config:             config,
+		runArgs:            runArgs,
+		groupPatterns:      groupPatterns,
+		skipPattern:        skipPattern,
+		summaryTemplate:    summaryTemplate,
+		runPatternTemplate: runPatternTemplate,
+		failFast:           failFast,
+		color:              useColor(config.NoColor),
+		tty:                tty,
 	}, nil
 }
 
+// Validate checks that the files and directories referenced by the config
+// exist, without building or running anything. It is called by Setup.
+func (r *Runner) Validate() error {
+	// Validate the data volumes.
+	if err := validateDataVolumes(r.config.DataVolumes); err != nil {
+		return err
+	}
+	if err := r.checkRemoteMounts(); err != nil {
+		return err
+	}
+
+	// Check that temporary files can be written.
+	if err := checkTmpDir(r.config.TmpDir); err != nil {
+		return err
+	}
+
+	// Check the test files exist.
+	for _, file := range r.config.TestFiles {
+		if !strings.HasSuffix(file, "_test.go") {
+			return fmt.Errorf("invalid test file %q: not a _test.go file", file)
+		}
+		if _, err := os.Stat(filepath.Join(r.config.TestDir, file)); err != nil {
+			return fmt.Errorf("invalid test file %q: %v", file, err)
+		}
+	}
+
+	// Read the test flags file.
+	if r.config.TestFlagsFile != "" {
+		flags, err := readTestFlagsFile(r.config.TestFlagsFile)
+		if err != nil {
+			return err
+		}
+		r.testFlags = flags
+	}
+
+	// Check the build secret files exist.
+	for _, id := range sortedKeys(r.config.BuildSecrets) {
+		if _, err := os.Stat(r.config.BuildSecrets[id]); err != nil {
+			return fmt.Errorf("invalid build secret %q: %v", id, err)
+		}
+	}
+
+	// Check the asset directories mounted into test containers are
+	// directories, if they exist.
+	for _, name := range []string{testdataDir, fixturesDir} {
+		if info, err := os.Stat(filepath.Join(r.config.TestDir, name)); err == nil && !info.IsDir() {
+			return fmt.Errorf("invalid %s directory in %s: not a directory", name, r.config.TestDir)
+		}
+	}
+
+	// Check the directories of the files written after the run exist.
+	for _, output := range []struct{ name, path string }{
+		{"bundle-path", r.config.BundlePath},
+		{"timings-export-path", r.config.TimingsExportPath},
+	} {
+		if output.path == "" {
+			continue
+		}
+		if info, err := os.Stat(filepath.Dir(output.path)); err != nil || !info.IsDir() {
+			return fmt.Errorf("invalid %s %s: directory %s does not exist", output.name, output.path, filepath.Dir(output.path))
+		}
+	}
+
+	// Find the build directory and check the Dockerfile exists.
+	return r.resolveBuildDir()
+}
+
 func (r *Runner) Setup() error {
-	// Initialize the container build image.
-	r.containerBuildImage = fmt.Sprintf("%s-%s:dev", containerBuildImagePrefix, randomShortID())
+	r.setupStart = time.Now()
+	r.parallelism = r.config.Parallelism
+	if err := r.Validate(); err != nil {
+		return err
+	}
 
-	// Build the docker image.
-	err := r.buildDockerImage()
-	if err != nil {
+	// Resolve the build tags.
+	if err := r.resolveBuildTags(); err != nil {
 		return err
 	}
 
+	if warning := r.platformMismatch(); warning != "" {
+		fmt.Printf("--- INFO: Build and run platforms differ: %s\n", warning)
+	}
+
+	// Initialize the run ID and container build image.
+	r.runID = r.config.RunID
+	if r.runID == "" {
+		r.runID = randomShortID()
+	}
+	fmt.Printf("--- INFO: Run ID %s\n", r.runID)
+	r.containerBuildImage = r.config.ImageName
+	if r.containerBuildImage == "" {
+		r.containerBuildImage = fmt.Sprintf("%s-%s:dev", containerBuildImagePrefix, randomShortID())
+	}
+	r.groupImages = nil
+	for i := range r.config.DockerfileGroups {
+		r.groupImages = append(r.groupImages, groupImageName(r.containerBuildImage, i))
+	}
+
+	// Check that the docker daemon is available.
+	if !r.config.SkipDockerCheck {
+		if err := r.checkDockerDaemon(); err != nil {
+			return err
+		}
+		r.checkDockerResources()
+	}
+
+	// Build the test image, or check the container to run the tests in.
+	if r.config.ExecInto != "" {
+		if err := r.checkExecContainer(); err != nil {
+			return err
+		}
+	} else {
+		buildStart := time.Now()
+		if err := r.prepareImage(); err != nil {
+			return err
+		}
+		r.buildDuration = time.Since(buildStart)
+	}
+	if r.config.SharedContainer {
+		if err := r.startSharedContainer(); err != nil {
+			return err
+		}
+	}
+
 	// Get tests to run.
+	var err error
 	r.testsToRun, err = r.getTestsToRun()
 	if err != nil {
 		return err
 	}
+	if r.config.MountBinary {
+		if err := r.checkMountBinaryTests(); err != nil {
+			return err
+		}
+	}
+	if len(r.testsToRun) == 0 {
+		if r.config.FailOnNoTests {
+			return fmt.Errorf("%w in %s", ErrNoTests, r.config.TestDir)
+		}
+		fmt.Printf("--- INFO: Build succeeded but no tests in %s match the filters\n", r.config.TestDir)
+	}
+	if r.config.RerunFailed {
+		if err := r.filterFailedTests(); err != nil {
+			return err
+		}
+	}
+	if r.config.SinceLastPass {
+		if err := r.filterUnchangedTests(); err != nil {
+			return err
+		}
+	}
+	r.seed = orderTests(r.testsToRun, r.config.Order, r.config.Seed)
+	if r.config.Order == OrderRandom {
+		fmt.Printf("--- INFO: Running tests in random order (seed %d)\n", r.seed)
+	}
+	if r.config.Shuffle {
+		if r.seed == 0 {
+			r.seed = time.Now().UnixNano()
+		}
+		fmt.Printf("--- INFO: Running tests with -test.shuffle=%d\n", r.seed)
+	}
+	r.expandTestsForMatrix()
+
+	// Find per-test fixtures.
+	if r.testFixtures, err = r.findTestFixtures(); err != nil {
+		return err
+	}
+	if err := r.checkRemoteMounts(); err != nil {
+		return err
+	}
+
+	// Wait for dependencies to be ready.
+	if err := r.waitForDependencies(); err != nil {
+		return err
+	}
+
+	// Run the setup command, e.g. to seed the dependencies.
+	r.setupCommandRan = true
+	if err := r.runSuiteCommand("setup", r.config.SetupCommand); err != nil {
+		return err
+	}
+
+	if r.config.Race {
+		r.parallelism = max(r.parallelism/2, 1)
+		fmt.Printf("--- INFO: Race detector enabled; tests are slower and use more memory, running at most %d in parallel\n", r.parallelism)
+	}
 
 	if r.config.Verbosity > 0 {
 		fmt.Printf("--- INFO: Running with verbosity %d\n", r.config.Verbosity)
@@ -87,19 +881,191 @@ func (r *Runner) Setup() error {
 	return nil
 }
 
-func (r *Runner) Cleanup() {}
+// prepareImage pulls the base image, builds the test image and, depending on
+// the config, pushes it and builds the test binary to mount into it.
+func (r *Runner) prepareImage() error {
+	// Pull the base image if configured.
+	if r.config.PullImage != "" {
+		if err := r.pullBaseImage(); err != nil {
+			return err
+		}
+	}
+
+	// Build the docker image, and that of each Dockerfile group.
+	dockerfile := r.config.Dockerfile
+	content := r.config.DockerfileContent
+	if r.config.DockerfileCommand != "" {
+		var err error
+		if content, err = r.generateDockerfile(); err != nil {
+			return err
+		}
+	}
+	if content != "" {
+		var err error
+		if dockerfile, err = r.writeInlineDockerfile(content); err != nil {
+			return err
+		}
+	}
+	if err := r.buildDockerImage(r.containerBuildImage, dockerfile); err != nil {
+		return err
+	}
+	for i, group := range r.config.DockerfileGroups {
+		if err := r.buildDockerImage(r.groupImages[i], group.Dockerfile); err != nil {
+			return err
+		}
+	}
+
+	// Push the image, e.g. to use it as a build cache in later runs.
+	if r.config.PushImage {
+		if err := r.pushImage(); err != nil {
+			return err
+		}
+	}
+
+	// Build the test binary to mount into the containers.
+	if r.config.MountBinary {
+		if err := r.buildTestBinary(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Cleanup removes the containers and files created by Setup, and its image
+// per CleanupPolicy. It may be called whether or not Setup succeeded.
+func (r *Runner) Cleanup() {
+	if r.setupCommandRan {
+		if err := r.runSuiteCommand("teardown", r.config.TeardownCommand); err != nil {
+			fmt.Printf("--- INFO: %v\n", err)
+		}
+		r.setupCommandRan = false
+	}
+	if r.sharedContainer != "" {
+		if err := r.removeContainer(r.sharedContainer); err != nil {
+			fmt.Printf("--- INFO: %v\n", err)
+		}
+	}
+	if r.tmpDir != "" {
+		if r.config.KeepTmpDir {
+			fmt.Printf("--- INFO: Keeping temporary files in %s\n", r.tmpDir)
+		} else {
+			os.RemoveAll(r.tmpDir)
+		}
+	}
+	r.removeImage()
+}
 
-func (r *Runner) buildDockerImage() error {
-	// Find the first go.mod file in any parent directory.
-	goModPath, err := findGoMod(r.config.TestDir)
+// BuildDir returns the docker build context directory resolved by Setup.
+func (r *Runner) BuildDir() string {
+	return r.buildDir
+}
+
+// testParallelism returns the maximum number of tests run at once: the
+// Parallelism set by Setup, or the configured one without Setup.
+func (r *Runner) testParallelism() int {
+	if r.parallelism > 0 {
+		return r.parallelism
+	}
+	return r.config.Parallelism
+}
+
+// FailFast reports whether the runner stops on the first failing test.
+func (r *Runner) FailFast() bool {
+	return r.failFast
+}
+
+// Image returns the name of the docker image built by Setup.
+func (r *Runner) Image() string {
+	return r.containerBuildImage
+}
+
+// TmpDir returns the temporary directory of the run, holding the test binary
+// of MountBinary and an inline Dockerfile, or "" if Setup did not need one.
+func (r *Runner) TmpDir() string {
+	return r.tmpDir
+}
+
+// makeTmpDir creates the temporary directory of the run in the configured
+// TmpDir, once, and returns it. Cleanup removes it unless KeepTmpDir is set.
+func (r *Runner) makeTmpDir() (string, error) {
+	if r.tmpDir != "" {
+		return r.tmpDir, nil
+	}
+	dir, err := os.MkdirTemp(r.config.TmpDir, "go-e2e-")
 	if err != nil {
-		return fmt.Errorf("failed to find go.mod: %v", err)
+		return "", fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	r.tmpDir = dir
+	return dir, nil
+}
+
+// checkTmpDir checks that temporary files can be created in TmpDir, if set.
+func checkTmpDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	f, err := os.CreateTemp(dir, "go-e2e-check-")
+	if err != nil {
+		return fmt.Errorf("tmp dir %s is not writable: %v", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// resolveBuildDir finds the docker build context directory, and checks that
+// the Dockerfiles exist. The build context is, in order of precedence:
+// GoModDir; the directory of a go.work file in or above the directory of the
+// first go.mod file in TestDir or any parent directory, if it uses that
+// module; or the directory of that go.mod file.
+func (r *Runner) resolveBuildDir() error {
+	if r.config.GoModDir != "" {
+		dir, err := filepath.Abs(r.config.GoModDir)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path: %v", err)
+		}
+		_, modErr := os.Stat(filepath.Join(dir, "go.mod"))
+		_, workErr := os.Stat(filepath.Join(dir, "go.work"))
+		if modErr != nil && workErr != nil {
+			return fmt.Errorf("invalid go-mod-dir %s: no go.mod or go.work file", dir)
+		}
+		r.buildDir = dir
+	} else {
+		dir, err := findBuildDir(r.config.TestDir)
+		if err != nil {
+			return fmt.Errorf("failed to find go.mod: %v", err)
+		}
+		r.buildDir = dir
 	}
-	goModDir := filepath.Dir(goModPath)
 	if r.config.Verbosity > 2 {
-		fmt.Printf("--- DEBUG: go.mod directory: %s\n", goModDir)
+		fmt.Printf("--- DEBUG: Build context directory: %s\n", r.buildDir)
 	}
 
+	if r.config.ExecInto != "" {
+		return nil
+	}
+
+	// Relative Dockerfile paths are resolved by docker against the build
+	// directory.
+	var dockerfiles []string
+	if r.config.Dockerfile != "" {
+		dockerfiles = append(dockerfiles, r.config.Dockerfile)
+	}
+	for _, group := range r.config.DockerfileGroups {
+		dockerfiles = append(dockerfiles, group.Dockerfile)
+	}
+	for _, dockerfile := range dockerfiles {
+		if !filepath.IsAbs(dockerfile) {
+			dockerfile = filepath.Join(r.buildDir, dockerfile)
+		}
+		if _, err := os.Stat(dockerfile); err != nil {
+			return fmt.Errorf("%w: %s", ErrDockerfileNotFound, dockerfile)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) buildDockerImage(image, dockerfile string) error {
 	// Print current working directory.
 	wd, err := os.Getwd()
 	if err != nil {
@@ -110,122 +1076,281 @@ func (r *Runner) buildDockerImage() error {
 	}
 
 	// Build the docker image.
-	fmt.Printf("--- INFO: Building docker image %s (this may take a while)...\n", r.containerBuildImage)
+	fmt.Printf("--- INFO: Building docker image %s (this may take a while)...\n", image)
 	start := time.Now()
-	buildCmd := exec.Command("docker", "build",
-		"-t", r.containerBuildImage,
-		"-f", r.config.Dockerfile,
-		".")
-	buildCmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
-	buildCmd.Dir = goModDir
-	if r.config.Verbosity > 1 {
-		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(buildCmd.Args, " "))
-	}
-	var output []byte
-	if r.config.Verbosity > 0 {
-		buildCmd.Stdout = os.Stdout
-		buildCmd.Stderr = os.Stderr
-		err = buildCmd.Run()
-	} else {
-		output, err = buildCmd.CombinedOutput()
+	attempts := r.config.BuildRetries + 1
+	buildTimeout := r.config.BuildTimeout
+	if buildTimeout <= 0 {
+		buildTimeout = defaultBuildTimeout
 	}
+	stopHeartbeat := startHeartbeat(os.Stdout, "building", start, r.config.BuildHeartbeat)
+	err = retryWithBackoff(attempts, buildInitialBackoff, func(attempt int) (bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), buildTimeout)
+		defer cancel()
+		buildCmd := exec.CommandContext(ctx, "docker", r.dockerBuildArgs(image, dockerfile)...)
+		buildCmd.Env = r.dockerBuildEnv()
+		buildCmd.Dir = r.buildDir
+		if r.config.Verbosity > 1 {
+			fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(buildCmd.Args, " "))
+		}
+		var output bytes.Buffer
+		if r.config.Verbosity > 0 {
+			buildCmd.Stdout = io.MultiWriter(os.Stdout, &output)
+			buildCmd.Stderr = io.MultiWriter(os.Stderr, &output)
+		} else {
+			buildCmd.Stdout = &output
+			buildCmd.Stderr = &output
+		}
+		if err := buildCmd.Run(); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return false, fmt.Errorf("docker build timed out after %s", buildTimeout)
+			}
+			if errors.Is(err, exec.ErrNotFound) {
+				return false, fmt.Errorf("%w: %v", ErrDaemonUnavailable, err)
+			}
+			if isDaemonUnavailableOutput(output.String()) {
+				return false, fmt.Errorf("%w\n%s", ErrDaemonUnavailable, output.String())
+			}
+			retry := isRetryableBuildOutput(output.String())
+			if retry && attempt < attempts {
+				fmt.Printf("--- INFO: docker build failed with a transient error (attempt %d/%d), retrying...\n", attempt, attempts)
+			}
+			return retry, newBuildError(output.String(), r.config.Verbosity > 0)
+		}
+		return false, nil
+	})
+	stopHeartbeat()
 	if err != nil {
-		return fmt.Errorf("failed to build docker image\n%s", output)
+		fmt.Printf("--- INFO: Build context: %s, Dockerfile: %s\n", r.buildDir, dockerfile)
+		return err
 	}
 	fmt.Printf("--- OK: docker build (%.2fs)\n", time.Since(start).Seconds())
 	return nil
 }
 
-func (r *Runner) getTestsToRun() ([]string, error) {
-	var tests []string
-	fset := token.NewFileSet()
-
-	// Split pattern by slashes if present.
-	// Match behaviour in https://pkg.go.dev/cmd/go/internal/test
-	var patterns []*regexp.Regexp
-	if r.config.TestPattern != "" {
-		for _, p := range strings.Split(r.config.TestPattern, "/") {
-			re, err := regexp.Compile(p)
-			if err != nil {
-				return nil, fmt.Errorf("invalid test pattern: %v", err)
-			}
-			patterns = append(patterns, re)
+// retryableBuildErrors are substrings of docker build output that indicate a
+// transient network failure rather than a problem with the Dockerfile.
+var retryableBuildErrors = []string{
+	"TLS handshake timeout",
+	"i/o timeout",
+	"connection reset by peer",
+	"unexpected EOF",
+	"temporary failure in name resolution",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+}
+
+// isRetryableBuildOutput reports whether a failed build's output contains a
+// transient network error.
+func isRetryableBuildOutput(output string) bool {
+	lower := strings.ToLower(output)
+	for _, e := range retryableBuildErrors {
+		if strings.Contains(lower, strings.ToLower(e)) {
+			return true
 		}
 	}
+	return false
+}
 
-	err := filepath.Walk(r.config.TestDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// dockerBuildArgs returns the arguments for the docker build command.
+func (r *Runner) dockerBuildArgs(image, dockerfile string) []string {
+	args := []string{"build",
+		"-t", image,
+		"-f", dockerfile}
+	if r.config.BuildTarget != "" {
+		args = append(args, "--target", r.config.BuildTarget)
+	}
+	if r.config.BuildPlatform != "" {
+		args = append(args, "--platform", r.config.BuildPlatform)
+	}
+	for _, id := range sortedKeys(r.config.BuildSecrets) {
+		args = append(args, "--secret", "id="+id+",src="+r.config.BuildSecrets[id])
+	}
+	args = append(args, labelArgs(r.imageLabels())...)
+	if r.config.PullImage != "" {
+		args = append(args, "--build-arg", "BASE_IMAGE="+r.config.PullImage)
+	}
+	if len(r.buildTags) > 0 {
+		args = append(args, "--build-arg", "BUILD_TAGS="+strings.Join(r.buildTags, ","))
+	}
+	if r.config.Race {
+		args = append(args, "--build-arg", "RACE=-race", "--build-arg", "CGO_ENABLED=1")
+	}
+	if r.config.AssetsMountPath != "" {
+		args = append(args, "--build-arg", "ASSETS_PATH="+r.config.AssetsMountPath)
+	}
+
+	// Pass Go module settings through as build args. A build arg without a
+	// value takes its value from the docker build command's environment.
+	env := r.dockerBuildEnv()
+	for _, name := range passthroughBuildEnv {
+		if envValue(env, name) != "" {
+			args = append(args, "--build-arg", name)
 		}
-		if !info.IsDir() && strings.HasSuffix(path, "_test.go") {
-			// Parse the file for test functions and build constraints.
-			f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
-			if err != nil {
-				return fmt.Errorf("failed to parse %s: %v", path, err)
-			}
+	}
 
-			for _, decl := range f.Decls {
-				funcDecl, ok := decl.(*ast.FuncDecl)
-				if !ok {
-					continue
-				}
-				if strings.HasPrefix(funcDecl.Name.Name, "Test") {
-					// If no pattern, run all tests
-					if len(patterns) == 0 {
-						tests = append(tests, funcDecl.Name.Name)
-						continue
-					}
-
-					// Check if test name matches all parts of the pattern
-					matches := true
-					for _, re := range patterns {
-						if !re.MatchString(funcDecl.Name.Name) {
-							matches = false
-							break
-						}
-					}
-					if matches {
-						tests = append(tests, funcDecl.Name.Name)
-					}
-				}
-			}
+	return append(args, ".")
+}
+
+// passthroughBuildEnv lists the Go environment variables that are passed to
+// the docker build as build args when set in the host environment or BuildEnv.
+var passthroughBuildEnv = []string{
+	"GOFLAGS",
+	"GOPROXY",
+	"GOPRIVATE",
+	"GONOPROXY",
+	"GONOSUMDB",
+	"GONOSUMCHECK",
+	"GOSUMDB",
+	"GOINSECURE",
+}
+
+// dockerBuildEnv returns the environment for the docker build command: the
+// host environment, overridden by BuildEnv, overridden by the forced
+// GOOS/GOARCH/CGO_ENABLED settings.
+func (r *Runner) dockerBuildEnv() []string {
+	env := os.Environ()
+	keys := make([]string, 0, len(r.config.BuildEnv))
+	for k := range r.config.BuildEnv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+r.config.BuildEnv[k])
+	}
+	if len(r.config.BuildSecrets) > 0 {
+		env = append(env, "DOCKER_BUILDKIT=1")
+	}
+	cgo := "0"
+	if r.cgoEnabled() {
+		cgo = "1"
+	}
+	return append(env, "GOOS=linux", "GOARCH="+r.goarch(), "CGO_ENABLED="+cgo)
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// envValue returns the value of the last occurrence of name in env, matching
+// the precedence used by exec.Cmd.
+func envValue(env []string, name string) string {
+	value := ""
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == name {
+			value = v
 		}
-		return nil
-	})
+	}
+	return value
+}
+
+// getTestsToRun returns the discovered tests selected to run, recording the
+// tests excluded by the skip pattern as skipped.
+func (r *Runner) getTestsToRun() ([]string, error) {
+	infos, err := r.discoverTests()
 	if err != nil {
-		return nil, fmt.Errorf("failed to find tests: %v", err)
+		return nil, err
+	}
+	var tests []string
+	for _, info := range infos {
+		switch {
+		case info.Excluded == "":
+			tests = append(tests, info.Name)
+		case info.envSkipped:
+			r.envSkippedTests = append(r.envSkippedTests, info.Name)
+		case info.skipped:
+			r.skippedTests = append(r.skippedTests, info.Name)
+		}
 	}
 	return tests, nil
 }
 
+// logExcluded explains at verbosity > 1 why a test function is not run.
+func (r *Runner) logExcluded(name, path, reason string) {
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Excluding %s in %s: %s\n", name, path, reason)
+	}
+}
+
+// matchesPatterns reports whether the test name matches all of the patterns.
+// It returns true if there are no patterns.
+func matchesPatterns(name string, patterns []*regexp.Regexp) bool {
+	for _, re := range patterns {
+		if !re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// RunTests runs the tests and prints a summary. It returns an error if any
+// test failed.
 func (r *Runner) RunTests() error {
-	ctx, cancel := context.WithCancel(context.Background())
+	return r.RunTestsContext(context.Background())
+}
+
+// RunTestsContext is like RunTests but stops running tests when ctx is done,
+// in which case it returns a TestsFailedError wrapping ctx.Err().
+func (r *Runner) RunTestsContext(ctx context.Context) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	if r.config.OnEvent != nil {
+		r.events = newEventQueue(r.config.OnEvent)
+		defer func() {
+			r.events.close()
+			r.events = nil
+		}()
+	}
+
 	var wg sync.WaitGroup
 	r.testTimings = make(map[string]time.Duration)
 
 	suiteStart := time.Now()
+	r.budgetSkippedTests = nil
+	r.tooSlowTests = nil
+	r.budgetDeadline = time.Time{}
+	if r.config.TimeBudget > 0 {
+		r.budgetDeadline = suiteStart.Add(r.config.TimeBudget)
+	}
+	if len(r.skippedTests) > 0 {
+		fmt.Printf("--- INFO: Skipping %d tests matching skip pattern %q\n", len(r.skippedTests), r.config.SkipPattern)
+	}
+	if len(r.envSkippedTests) > 0 {
+		fmt.Printf("--- INFO: Skipping %d tests whose required environment variables are not set\n", len(r.envSkippedTests))
+	}
 	switch len(r.testsToRun) {
 	case 1:
 		fmt.Printf("--- INFO: Running 1 test...\n")
 	case 0:
 		fmt.Printf("--- INFO: No tests to run.\n")
 	default:
-		fmt.Printf("--- INFO: Running %d tests %s...\n", len(r.testsToRun), map[bool]string{true: "sequentially", false: fmt.Sprintf("in parallel (max %d)", r.config.Parallelism)}[r.config.NoParallel])
+		fmt.Printf("--- INFO: Running %d tests %s...\n", len(r.testsToRun), map[bool]string{true: "sequentially", false: fmt.Sprintf("in parallel (max %d)", r.testParallelism())}[r.config.NoParallel])
 	}
 
-	sem := make(chan struct{}, r.config.Parallelism)
+	r.emit(Event{Type: EventRunStart})
+
+	// Tests are started in order as their weight fits in the parallelism,
+	// so that heavy tests take several slots.
+	sem := newWeightedSemaphore(r.testParallelism())
 
 	for _, test := range r.testsToRun {
 		if r.config.NoParallel {
 			r.runTest(ctx, test, cancel)
 		} else {
+			weight := r.testWeight(test)
+			sem.acquire(weight)
 			wg.Add(1)
 			go func(test string) {
 				defer wg.Done()
-				sem <- struct{}{}
-				defer func() { <-sem }()
+				defer sem.release(weight)
 				r.runTest(ctx, test, cancel)
 			}(test)
 		}
@@ -235,115 +1360,423 @@ func (r *Runner) RunTests() error {
 		wg.Wait()
 	}
 	suiteDuration := time.Since(suiteStart)
+	r.suiteDuration = suiteDuration
+	r.stopped = parent.Err() != nil
 
 	r.printSummary(suiteDuration)
+	suiteStatus := r.suiteStatus()
+	r.emit(Event{Type: EventSuiteDone, Status: suiteStatus, Duration: suiteDuration})
+	r.notifyWebhook(suiteStatus)
 
-	if len(r.failedTests) > 0 {
-		return fmt.Errorf("tests failed")
+	// Save the state first, so that failing to write the outputs below does
+	// not leave the previous run's state for rerun-failed and
+	// since-last-pass.
+	if err := r.saveState(); err != nil {
+		fmt.Printf("--- INFO: Failed to save run state: %v\n", err)
+	}
+	if r.config.TimingsExportPath != "" {
+		if err := r.exportTimings(r.config.TimingsExportPath); err != nil {
+			return err
+		}
+	}
+	if r.config.BundlePath != "" {
+		if err := r.writeBundle(r.config.BundlePath, suiteDuration); err != nil {
+			return err
+		}
+	}
+
+	r.passed = len(r.failedTests) == 0 && len(r.incompleteTests) == 0 && !r.stopped
+	if r.config.StrictMode {
+		if tests, _ := r.notPassedTests(); len(tests) > 0 {
+			r.passed = false
+			return &StrictModeError{NotPassed: tests}
+		}
+	}
+	if len(r.failedTests) > 0 || r.stopped {
+		err := &TestsFailedError{
+			Passed:     len(r.passedTests),
+			Failed:     len(r.failedTests),
+			Incomplete: len(r.incompleteTests),
+			Cancelled:  len(r.cancelledTests),
+		}
+		if r.stopped {
+			err.Err = parent.Err()
+		}
+		return err
 	}
 	return nil
 }
 
+// suiteStatus returns the status of the run: FAIL if a test failed or
+// StrictMode failed it, STOP if the caller's context was done before all
+// tests finished, and PASS otherwise.
+func (r *Runner) suiteStatus() string {
+	switch {
+	case len(r.failedTests) > 0 || r.strictModeFailed():
+		return "FAIL"
+	case r.stopped:
+		return "STOP"
+	default:
+		return "PASS"
+	}
+}
+
 func (r *Runner) runTest(ctx context.Context, test string, cancel context.CancelFunc) {
-	fmt.Printf("=== RUN: %s\n", test)
-	start := time.Now()
+	// Wait for a container slot shared with other runners, if limited.
+	if r.config.ContainerLimiter.acquire(ctx) {
+		defer r.config.ContainerLimiter.release()
+	}
 
-	args := []string{"run", "--rm", "--tty",
-		"--name", sanitizeContainerName(test)}
-	if len(r.config.DockerRunArgs) > 0 {
-		for _, arg := range r.config.DockerRunArgs {
-			args = append(args, strings.Fields(arg)...)
+	// Don't start tests once the run has been cancelled.
+	if ctx.Err() != nil {
+		r.mu.Lock()
+		if !slices.Contains(r.incompleteTests, test) {
+			r.incompleteTests = append(r.incompleteTests, test)
 		}
+		r.mu.Unlock()
+		return
 	}
-	args = append(args, r.containerBuildImage, "-test.run", fmt.Sprintf("^%s$", test))
-	if r.config.Verbosity > 0 {
-		args = append(args, "-test.v")
-	}
-	cmd := exec.CommandContext(ctx, "docker", args...)
-	if r.config.Verbosity > 1 {
-		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+
+	// Don't start tests once the time budget is spent.
+	if !r.budgetDeadline.IsZero() && time.Now().After(r.budgetDeadline) {
+		r.mu.Lock()
+		r.budgetSkippedTests = append(r.budgetSkippedTests, test)
+		r.mu.Unlock()
+		return
 	}
 
-	var output bytes.Buffer
-	if r.config.Verbosity > 0 {
-		cmd.Stdout = io.MultiWriter(os.Stdout, &output)
-		cmd.Stderr = io.MultiWriter(os.Stderr, &output)
-	} else {
-		cmd.Stdout = &output
-		cmd.Stderr = &output
+	if !r.config.Compact {
+		fmt.Printf("=== RUN: %s\n", test)
 	}
+	r.emit(Event{Type: EventTestStart, Test: test})
+	start := time.Now()
 
-	if err := cmd.Run(); err != nil {
+	args, output, err := r.execTest(ctx, test)
+	if err != nil && r.execContainer == "" && isNameConflict(err, output.stderr.String()) {
+		fmt.Printf("--- INFO: Container name for %s is already in use, retrying with a new name\n", test)
+		args, output, err = r.execTest(ctx, test)
+	}
+	for attempt := 1; err != nil && r.retryTest(ctx, err, attempt); attempt++ {
+		fmt.Printf("--- INFO: Retrying %s after %v (retry %d/%d)\n", test, err, attempt, r.config.TestRetries)
+		args, output, err = r.execTest(ctx, test)
+	}
+	if err == nil {
+		err = r.checkTestHealth(ctx, test, output)
+	}
+	if err == nil {
+		err = r.checkTestDuration(test, time.Since(start), output)
+	}
+	// Append the service logs of a failed test to its output before it is
+	// recorded, so that they are kept wherever the output is.
+	var serviceLogs string
+	if err != nil && r.config.CollectServiceLogs && ctx.Err() == nil {
+		serviceLogs = r.serviceLogs(start, time.Now())
+		output.WriteString(serviceLogs)
+	}
+	r.recordForBundle(test, args, output)
+	r.mu.Lock()
+	if r.testStreams == nil {
+		r.testStreams = make(map[string]testStreams)
+	}
+	r.testStreams[test] = testStreams{output: output.String(), stdout: output.stdout.String(), stderr: output.stderr.String()}
+	r.mu.Unlock()
+	if err != nil {
+		cancelled := ctx.Err() != nil
 		r.mu.Lock()
-		if len(r.failedTests) == 0 {
-			if !r.config.NoFastFail {
-				cancel()
-				for _, t := range r.testsToRun {
-					t = strings.TrimSpace(t)
-					if t == "" || t == test {
-						continue
-					}
-					ran := false
-					for _, pt := range r.passedTests {
-						if pt == t {
-							ran = true
-							break
-						}
-					}
-					for _, ft := range r.failedTests {
-						if ft == t {
-							ran = true
-							break
-						}
-					}
-					if !ran {
-						r.incompleteTests = append(r.incompleteTests, t)
-					}
-				}
-			}
+		// With fail-fast, only the first failure is reported; tests failing
+		// after it were cancelled rather than failing on their own.
+		first := len(r.failedTests) == 0
+		if first && r.failFast {
+			cancel()
+			r.markIncompleteTests(test)
 		}
 		r.failedTests = append(r.failedTests, test)
+		if cancelled {
+			r.cancelledTests = append(r.cancelledTests, test)
+		}
 		r.testTimings[test] = time.Since(start)
+		duration := r.testTimings[test]
 		r.mu.Unlock()
-		if test == r.failedTests[0] {
-			if r.config.Verbosity > 0 {
-				fmt.Printf("--- FAIL: %s (%.2fs)\n", test, r.testTimings[test].Seconds())
-			} else {
-				fmt.Printf("--- FAIL: %s (%.2fs)\n%s", test, r.testTimings[test].Seconds(), output.String())
+		if first || !r.failFast {
+			// The test's output was streamed with verbose output, but not its
+			// service logs, and with DedupeFailures it is printed in the
+			// summary.
+			switch {
+			case r.config.Verbosity > 0:
+				fmt.Print(r.resultLine("FAIL", test, duration) + serviceLogs)
+			case r.config.DedupeFailures:
+				fmt.Print(r.resultLine("FAIL", test, duration))
+			default:
+				fmt.Print(r.resultLine("FAIL", test, duration) + r.failureOutput(output.String()))
 			}
 		}
+		r.emit(Event{Type: EventTestFinish, Test: test, Status: "FAIL", Duration: duration})
 	} else {
+		status := "PASS"
+		if r.config.ReportSkips && testSkipped(output.String(), r.testName(test)) {
+			status = "SKIP"
+		}
 		r.mu.Lock()
-		r.passedTests = append(r.passedTests, test)
+		if status == "SKIP" {
+			r.selfSkippedTests = append(r.selfSkippedTests, test)
+		} else {
+			r.passedTests = append(r.passedTests, test)
+		}
 		r.testTimings[test] = time.Since(start)
+		duration := r.testTimings[test]
 		r.mu.Unlock()
-		fmt.Printf("--- PASS: %s (%.2fs)\n", test, r.testTimings[test].Seconds())
+		fmt.Print(r.resultLine(status, test, duration))
+		r.emit(Event{Type: EventTestFinish, Test: test, Status: status, Duration: duration})
 	}
 }
 
-func (r *Runner) printSummary(suiteDuration time.Duration) {
-	fmt.Println()
-	if len(r.failedTests) == 0 {
-		fmt.Printf("=== SUMMARY: PASS (%.2fs)\n", suiteDuration.Seconds())
-		for _, test := range r.passedTests {
-			fmt.Printf("PASS: %s (%.2fs)\n", test, r.testTimings[test].Seconds())
+// execTest runs a test's container, with the before-each and after-each
+// hooks, and returns its docker arguments, output and error.
+func (r *Runner) execTest(ctx context.Context, test string) ([]string, *testOutput, error) {
+	containerName := r.newContainerName(test)
+	args := r.dockerRunArgs(test, containerName)
+	if r.execContainer != "" {
+		args = r.dockerExecArgs(test)
+	}
+	timeout := r.testTimeout(test)
+	var testCtx context.Context
+	var testCancel context.CancelFunc
+	if timeout > 0 {
+		testCtx, testCancel = context.WithTimeout(ctx, timeout)
+	} else {
+		testCtx, testCancel = context.WithCancel(ctx)
+	}
+	defer testCancel()
+	cmd := exec.CommandContext(testCtx, "docker", args...)
+	// Killing docker run on cancellation would leave its container running,
+	// so stop or remove the container instead, and kill docker run only if it
+	// doesn't exit after.
+	switch {
+	case r.execContainer != "":
+		// The container is shared by all tests, so docker exec is killed,
+		// and the shared container restarted below.
+	case r.gracefulStop():
+		// Stop the container so the test binary can shut down cleanly.
+		cmd.Cancel = func() error {
+			return r.stopContainer(containerName)
 		}
+		cmd.WaitDelay = r.stopTimeout() + 5*time.Second
+	default:
+		cmd.Cancel = func() error {
+			return r.removeContainer(containerName)
+		}
+		cmd.WaitDelay = 5 * time.Second
+	}
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+	}
+
+	output := newTestOutput(r.config.MaxOutputBytes)
+	cmd.Stdout = io.MultiWriter(output, &output.stdout)
+	cmd.Stderr = io.MultiWriter(output, &output.stderr)
+	if r.config.Verbosity > 0 {
+		cmd.Stdout = io.MultiWriter(os.Stdout, cmd.Stdout)
+		cmd.Stderr = io.MultiWriter(os.Stderr, cmd.Stderr)
+	}
+
+	err := r.waitForTestDependencies(test)
+	if err != nil {
+		fmt.Fprintf(output, "--- ERROR: %v\n", err)
 	} else {
-		fmt.Printf("=== SUMMARY: FAIL (%.2fs)\n", suiteDuration.Seconds())
-		for _, test := range r.passedTests {
-			fmt.Printf("PASS: %s (%.2fs)\n", test, r.testTimings[test].Seconds())
+		err = r.runHook("before-each", r.config.BeforeEachCommand, test, cmd.Stdout, cmd.Stderr)
+	}
+	if err == nil {
+		var idle *idleWatcher
+		if r.config.IdleTimeout > 0 {
+			idle = watchIdle(r.config.IdleTimeout, testCancel)
+			cmd.Stdout = io.MultiWriter(cmd.Stdout, idle)
+			cmd.Stderr = io.MultiWriter(cmd.Stderr, idle)
 		}
-		if !r.config.NoFastFail {
-			for _, test := range r.failedTests {
-				fmt.Printf("FAIL: %s (%.2fs)\n", test, r.testTimings[test].Seconds())
+		err = cmd.Run()
+		// Killing docker exec leaves the test running in the shared
+		// container, where it would overlap the next test.
+		if r.sharedContainer != "" && testCtx.Err() != nil {
+			if rerr := r.restartSharedContainer(); rerr != nil {
+				fmt.Printf("--- INFO: %v\n", rerr)
 			}
+		}
+		if r.restartContainers() && r.execContainer == "" {
+			err = r.waitForRestarts(testCtx, containerName, err, output)
+			// The container may already be removed if the test was cancelled.
+			_ = r.removeContainer(containerName)
+		}
+		if idle != nil && idle.Stop() {
+			err = fmt.Errorf("no output for %s", r.config.IdleTimeout)
+			fmt.Fprintf(output, "\n--- ERROR: Test killed after producing no output for %s\n", r.config.IdleTimeout)
+		}
+		if errors.Is(testCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			err = fmt.Errorf("timed out after %s", timeout)
+			fmt.Fprintf(output, "\n--- ERROR: Test killed after exceeding its %s timeout\n", timeout)
+		}
+		if herr := r.runHook("after-each", r.config.AfterEachCommand, test, cmd.Stdout, cmd.Stderr); err == nil {
+			err = herr
+		}
+	}
+	return args, output, err
+}
+
+// dockerRunArgs returns the docker run arguments for a test.
+func (r *Runner) dockerRunArgs(test, containerName string) []string {
+	name := r.testName(test)
+	args := []string{"run", "--name", containerName}
+	if r.restartContainers() {
+		args = append(args, "--restart", r.config.RestartPolicy)
+	} else {
+		args = append(args, "--rm")
+	}
+	if r.tty {
+		args = append(args, "--tty")
+	}
+	if r.config.RunPlatform != "" {
+		args = append(args, "--platform", r.config.RunPlatform)
+	}
+	for _, vol := range r.config.DataVolumes {
+		args = append(args, "-v", vol)
+	}
+	for _, host := range r.config.AddHosts {
+		args = append(args, "--add-host", host)
+	}
+	args = append(args, r.dnsArgs()...)
+	if r.runID != "" {
+		args = append(args, "-e", "E2E_RUN_ID="+r.runID)
+	}
+	args = append(args, r.fixtureArgs(name)...)
+	args = append(args, r.assetsArgs()...)
+	args = append(args, r.matrixEnvArgs(test)...)
+	args = append(args, r.mountBinaryArgs()...)
+	if r.config.Entrypoint != "" {
+		args = append(args, "--entrypoint", r.config.Entrypoint)
+	}
+	if r.config.User != "" {
+		args = append(args, "--user", r.config.User)
+	}
+	args = append(args, labelArgs(r.containerLabels(name))...)
+	args = append(args, r.runArgs...)
+	args = append(args, r.imageFor(test), "-test.run", r.runPattern(name))
+	if r.config.Verbosity > 0 || r.config.ReportSkips {
+		args = append(args, "-test.v")
+	}
+	args = append(args, r.shuffleArgs()...)
+	return append(args, r.testFlags...)
+}
+
+// RunID returns the ID of the run, set by Setup, which test containers get
+// as the E2E_RUN_ID environment variable and e2e.run label.
+func (r *Runner) RunID() string {
+	return r.runID
+}
+
+// containerLabels returns the labels for a test's container.
+func (r *Runner) containerLabels(test string) map[string]string {
+	labels := make(map[string]string, len(r.config.Labels)+2)
+	for k, v := range r.config.Labels {
+		labels[k] = v
+	}
+	labels["e2e.test"] = test
+	labels["e2e.run"] = r.runID
+	return labels
+}
+
+// imageLabels returns the labels of the built image.
+func (r *Runner) imageLabels() map[string]string {
+	labels := make(map[string]string, len(r.config.ImageLabels)+1)
+	for k, v := range r.config.ImageLabels {
+		labels[k] = v
+	}
+	labels["e2e.built-at"] = time.Now().UTC().Format(time.RFC3339)
+	return labels
+}
+
+// labelArgs returns --label arguments for the labels, sorted by key.
+func labelArgs(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		args = append(args, "--label", k+"="+labels[k])
+	}
+	return args
+}
+
+// markIncompleteTests records every test that has not yet passed or failed,
+// other than the given failed test, as incomplete. Must be called with r.mu held.
+func (r *Runner) markIncompleteTests(failed string) {
+	done := make(map[string]bool, len(r.passedTests)+len(r.failedTests)+1)
+	done[failed] = true
+	for _, t := range r.passedTests {
+		done[t] = true
+	}
+	for _, t := range r.failedTests {
+		done[t] = true
+	}
+	for _, t := range r.testsToRun {
+		t = strings.TrimSpace(t)
+		if t == "" || done[t] {
+			continue
+		}
+		r.incompleteTests = append(r.incompleteTests, t)
+	}
+}
+
+func (r *Runner) printSummary(suiteDuration time.Duration) {
+	if r.summaryTemplate != nil {
+		r.printTemplateSummary()
+		return
+	}
+	fmt.Println()
+	fmt.Printf("=== SUMMARY: %s (%.2fs)\n", r.status(r.suiteStatus()), suiteDuration.Seconds())
+	for _, test := range r.passedTests {
+		fmt.Printf("%s: %s (%.2fs)\n", r.status("PASS"), test, r.testTimings[test].Seconds())
+	}
+	for _, test := range r.selfSkippedTests {
+		fmt.Printf("%s: %s (%.2fs)\n", r.status("SKIP"), test, r.testTimings[test].Seconds())
+	}
+	for _, test := range r.budgetSkippedTests {
+		fmt.Printf("%s: %s (budget exceeded)\n", r.status("SKIP"), test)
+	}
+	for _, test := range r.envSkippedTests {
+		fmt.Printf("%s: %s (%s not set)\n", r.status("SKIP"), test, strings.Join(r.missingEnv(test), ", "))
+	}
+	if len(r.failedTests) > 0 {
+		if r.failFast {
+			fmt.Printf("%s: %s (%.2fs)\n", r.status("FAIL"), r.failedTests[0], r.testTimings[r.failedTests[0]].Seconds())
 		} else {
-			fmt.Printf("FAIL: %s (%.2fs)\n", r.failedTests[0], r.testTimings[r.failedTests[0]].Seconds())
-			for _, test := range r.incompleteTests {
-				fmt.Printf("STOP: %s\n", test)
+			for _, test := range r.failedTests {
+				fmt.Printf("%s: %s (%.2fs)\n", r.status("FAIL"), test, r.testTimings[test].Seconds())
 			}
 		}
 	}
+	// Tests are incomplete after a fail-fast failure or when the caller's
+	// context is done.
+	for _, test := range r.incompleteTests {
+		fmt.Printf("%s: %s\n", r.status("STOP"), test)
+	}
+	if r.config.DedupeFailures && r.config.Verbosity == 0 {
+		r.printFailureGroups()
+	}
+	if len(r.selfSkippedTests) > 0 {
+		fmt.Printf("--- INFO: %d tests skipped themselves with t.Skip\n", len(r.selfSkippedTests))
+	}
+	if r.config.Shuffle {
+		fmt.Printf("--- INFO: Tests ran with -test.shuffle=%d; replay the order with -shuffle -seed %d\n", r.seed, r.seed)
+	}
+	if len(r.budgetSkippedTests) > 0 {
+		fmt.Printf("--- INFO: %d tests skipped after exceeding the %s time budget\n", len(r.budgetSkippedTests), r.config.TimeBudget)
+	}
+	r.printTooSlowTests()
+	r.printNotPassedTests()
+	r.printMatrixSummary()
+	r.printSlowestTests()
+	r.printStats(suiteDuration)
+	r.printTimes(suiteDuration)
 }
 
 // sanitizeContainerName converts a test name to a valid Docker container name
@@ -359,8 +1792,118 @@ func sanitizeContainerName(testName string) string {
 	return fmt.Sprintf("e2e-%s-%s", name, randomShortID())
 }
 
+// newContainerName returns a container name for a test that is not used by
+// another container of the run.
+func (r *Runner) newContainerName(test string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.containerNames == nil {
+		r.containerNames = make(map[string]bool)
+	}
+	for {
+		name := sanitizeContainerName(test)
+		if !r.containerNames[name] {
+			r.containerNames[name] = true
+			return name
+		}
+	}
+}
+
+// isNameConflict reports whether docker run failed because its container
+// name is already in use, e.g. by a container left over from another run.
+// Exit code 125 means docker itself failed before the container started, so
+// the stderr is docker's own and not output of the test.
+func isNameConflict(err error, stderr string) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 125 {
+		return false
+	}
+	for line := range strings.Lines(stderr) {
+		if strings.HasPrefix(line, "docker: Error response from daemon: Conflict. The container name ") &&
+			strings.Contains(line, "is already in use by container") {
+			return true
+		}
+	}
+	return false
+}
+
+// randomShortID returns a random 48-bit hex ID used to keep image and
+// container names unique across concurrent and back-to-back runs.
 func randomShortID() string {
-	return fmt.Sprintf("%04x", rand.Intn(65536))
+	b := make([]byte, 6)
+	// crypto/rand.Read never returns an error on supported platforms.
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validateDataVolumes checks that each volume spec is of the form
+// host:container[:ro|rw], that the host path exists, and that no two specs
+// mount to the same container path.
+func validateDataVolumes(specs []string) error {
+	containerPaths := make(map[string]string)
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid data volume %q: expected host:container[:ro|rw]", spec)
+		}
+		if len(parts) == 3 && parts[2] != "ro" && parts[2] != "rw" {
+			return fmt.Errorf("invalid data volume %q: mode must be ro or rw", spec)
+		}
+		if !filepath.IsAbs(parts[0]) {
+			return fmt.Errorf("invalid data volume %q: host path must be absolute", spec)
+		}
+		if _, err := os.Stat(parts[0]); err != nil {
+			return fmt.Errorf("invalid data volume %q: %v", spec, err)
+		}
+		if other, ok := containerPaths[parts[1]]; ok {
+			return fmt.Errorf("data volumes %q and %q mount to the same container path", other, spec)
+		}
+		containerPaths[parts[1]] = spec
+	}
+	return nil
+}
+
+// validateAddHosts checks that each entry is of the form hostname:ip.
+func validateAddHosts(hosts []string) error {
+	for _, host := range hosts {
+		// Split on the first colon only, as IPv6 addresses contain colons.
+		name, ip, ok := strings.Cut(host, ":")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid add-host entry %q: expected hostname:ip", host)
+		}
+		if ip != "host-gateway" && net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid add-host entry %q: %q is not an IP address", host, ip)
+		}
+	}
+	return nil
+}
+
+// validateDNS checks that each DNS server is an IP address and each search
+// domain a hostname.
+func validateDNS(servers, searchDomains []string) error {
+	for _, server := range servers {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("invalid dns entry %q: expected an IP address", server)
+		}
+	}
+	for _, domain := range searchDomains {
+		if domain == "" || strings.ContainsAny(domain, " \t:/") {
+			return fmt.Errorf("invalid dns-search entry %q: expected a domain name", domain)
+		}
+	}
+	return nil
+}
+
+// dnsArgs returns the docker run arguments for DNS and DNSSearch.
+func (r *Runner) dnsArgs() []string {
+	var args []string
+	for _, server := range r.config.DNS {
+		args = append(args, "--dns", server)
+	}
+	for _, domain := range r.config.DNSSearch {
+		args = append(args, "--dns-search", domain)
+	}
+	return args
 }
 
 func findGoMod(dir string) (string, error) {
@@ -0,0 +1,2915 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRandomShortIDUnique(t *testing.T) {
+	const n = 10000
+	seen := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		id := randomShortID()
+		if seen[id] {
+			t.Fatalf("duplicate id %q after %d ids", id, i)
+		}
+		seen[id] = true
+	}
+}
+
+func TestSanitizeContainerNameUnique(t *testing.T) {
+	a := sanitizeContainerName("TestSomethingWithAVeryLongSharedPrefixA")
+	b := sanitizeContainerName("TestSomethingWithAVeryLongSharedPrefixB")
+	if a == b {
+		t.Fatalf("expected distinct container names, got %q twice", a)
+	}
+	if !strings.HasPrefix(a, "e2e-TestSomethingWithAV") {
+		t.Errorf("unexpected container name %q", a)
+	}
+}
+
+func TestContainerNameConflictRetry(t *testing.T) {
+	bin := t.TempDir()
+	names := filepath.Join(bin, "names")
+	writeTestFile(t, bin, "docker", `#!/bin/sh
+echo "$3" >> `+names+`
+if [ ! -e `+names+`.conflict ]; then
+	touch `+names+`.conflict
+	echo "docker: Error response from daemon: Conflict. The container name \"/$3\" is already in use by container \"abc\"." >&2
+	exit 125
+fi
+`)
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TTY: new(bool)})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.testTimings = make(map[string]time.Duration)
+	r.runTest(t.Context(), "TestA", func() {})
+	if fmt.Sprint(r.passedTests) != "[TestA]" {
+		t.Errorf("expected TestA to pass after retrying, got passed %v, failed %v", r.passedTests, r.failedTests)
+	}
+	data, err := os.ReadFile(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used := strings.Fields(string(data)); len(used) != 2 || used[0] == used[1] {
+		t.Errorf("expected two distinct container names, got %v", used)
+	}
+}
+
+func TestContainerNameConflictOnlyFromDocker(t *testing.T) {
+	bin := t.TempDir()
+	names := filepath.Join(bin, "names")
+	// The test itself prints docker's conflict message and fails.
+	writeTestFile(t, bin, "docker", `#!/bin/sh
+echo "$3" >> `+names+`
+echo "docker: Error response from daemon: Conflict. The container name \"/db\" is already in use by container \"abc\"." >&2
+exit 1
+`)
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TTY: new(bool)})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.testTimings = make(map[string]time.Duration)
+	r.runTest(t.Context(), "TestA", func() {})
+	data, err := os.ReadFile(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used := strings.Fields(string(data)); len(used) != 1 {
+		t.Errorf("expected the test to run once, got container names %v", used)
+	}
+}
+
+func TestServiceLogsRecorded(t *testing.T) {
+	bin := t.TempDir()
+	writeTestFile(t, bin, "docker", `#!/bin/sh
+if [ "$1" = logs ]; then
+	echo "db: connection reset"
+	exit 0
+fi
+echo "boom"
+exit 1
+`)
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// Service logs are kept in the results whatever the verbosity.
+	for _, verbosity := range []int{0, 1} {
+		r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TTY: new(bool), WaitFor: []string{"container:db"}, CollectServiceLogs: true, Verbosity: verbosity})
+		if err != nil {
+			t.Fatalf("failed to create runner: %v", err)
+		}
+		r.testTimings = make(map[string]time.Duration)
+		r.runTest(t.Context(), "TestA", func() {})
+		results := r.Results()
+		if len(results.Tests) != 1 || !strings.Contains(results.Tests[0].Output, "db: connection reset") {
+			t.Errorf("expected service logs in TestA's output with verbosity %d, got %+v", verbosity, results.Tests)
+		}
+	}
+}
+
+func TestMirrorImage(t *testing.T) {
+	for image, want := range map[string]string{
+		"alpine:3.19":                 "mirror.example.com/library/alpine:3.19",
+		"golang":                      "mirror.example.com/library/golang",
+		"docker.io/alpine:3.19":       "mirror.example.com/library/alpine:3.19",
+		"bitnami/postgresql:16":       "mirror.example.com/bitnami/postgresql:16",
+		"docker.io/bitnami/redis:7.2": "mirror.example.com/bitnami/redis:7.2",
+	} {
+		if got := mirrorImage("mirror.example.com/", image); got != want {
+			t.Errorf("mirrorImage(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	calls := 0
+	err := retryWithBackoff(3, 0, func(attempt int) (bool, error) {
+		calls++
+		if attempt < 3 {
+			return true, fmt.Errorf("attempt %d failed", attempt)
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+
+	calls = 0
+	err = retryWithBackoff(2, 0, func(attempt int) (bool, error) {
+		calls++
+		return true, fmt.Errorf("attempt %d failed", attempt)
+	})
+	if err == nil || err.Error() != "attempt 2 failed" {
+		t.Errorf("expected last error, got: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+
+	calls = 0
+	err = retryWithBackoff(3, 0, func(attempt int) (bool, error) {
+		calls++
+		return false, fmt.Errorf("attempt %d failed", attempt)
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("expected a single call for a non-retryable error, got %d calls and error %v", calls, err)
+	}
+}
+
+func TestValidateDataVolumes(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		specs   []string
+		wantErr bool
+	}{
+		{"empty", nil, false},
+		{"read-only", []string{dir + ":/data:ro"}, false},
+		{"no mode", []string{dir + ":/data"}, false},
+		{"missing container path", []string{dir}, true},
+		{"bad mode", []string{dir + ":/data:rx"}, true},
+		{"relative host path", []string{"data:/data"}, true},
+		{"missing host path", []string{dir + "/missing:/data"}, true},
+		{"duplicate container path", []string{dir + ":/data", dir + ":/data:ro"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDataVolumes(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateDataVolumes(%v) error = %v, wantErr %v", tt.specs, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTmpDir(t *testing.T) {
+	tmp := t.TempDir()
+	if err := checkTmpDir(tmp); err != nil {
+		t.Errorf("unexpected error for writable tmp dir: %v", err)
+	}
+	if err := checkTmpDir(filepath.Join(tmp, "missing")); err == nil {
+		t.Errorf("expected error for missing tmp dir")
+	}
+}
+
+func TestKeepTmpDir(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		r, err := NewRunner(RunnerConfig{DockerfileContent: "FROM scratch\n", TmpDir: t.TempDir(), KeepTmpDir: keep})
+		if err != nil {
+			t.Fatalf("failed to create runner: %v", err)
+		}
+		if r.TmpDir() != "" {
+			t.Errorf("expected no temp dir before Setup, got %s", r.TmpDir())
+		}
+		path, err := r.writeInlineDockerfile(r.config.DockerfileContent)
+		if err != nil {
+			t.Fatalf("failed to write inline Dockerfile: %v", err)
+		}
+		if filepath.Dir(path) != r.TmpDir() || filepath.Dir(r.TmpDir()) != r.config.TmpDir {
+			t.Errorf("expected inline Dockerfile in the run's temp dir %s under %s, got %s", r.TmpDir(), r.config.TmpDir, path)
+		}
+		out := captureStdout(t, r.Cleanup)
+		_, err = os.Stat(path)
+		if keep {
+			if err != nil {
+				t.Errorf("expected the temp dir to be kept: %v", err)
+			}
+			if !strings.Contains(out, "--- INFO: Keeping temporary files in "+r.TmpDir()+"\n") {
+				t.Errorf("expected the kept temp dir to be printed, got %q", out)
+			}
+		} else {
+			if !os.IsNotExist(err) {
+				t.Errorf("expected the temp dir to be removed, got %v", err)
+			}
+			if out != "" {
+				t.Errorf("unexpected output %q", out)
+			}
+		}
+	}
+}
+
+func TestDockerBuildArgsPassesBuildTarget(t *testing.T) {
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", BuildTarget: "test"})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if args := strings.Join(r.dockerBuildArgs(r.containerBuildImage, r.config.Dockerfile), " "); !strings.Contains(args, "--target test ") {
+		t.Errorf("expected --target test in %q", args)
+	}
+}
+
+func TestDockerBuildArgsRace(t *testing.T) {
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", Race: true})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if args := strings.Join(r.dockerBuildArgs(r.containerBuildImage, r.config.Dockerfile), " "); !strings.Contains(args, "--build-arg RACE=-race --build-arg CGO_ENABLED=1 ") {
+		t.Errorf("expected race build args in %q", args)
+	}
+	if got := envValue(r.dockerBuildEnv(), "CGO_ENABLED"); got != "1" {
+		t.Errorf("expected CGO_ENABLED=1, got %q", got)
+	}
+}
+
+func TestDockerBuildArgsPassesBuildSecrets(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "token", "secret")
+	r, err := NewRunner(RunnerConfig{
+		Dockerfile:   "Dockerfile",
+		BuildSecrets: map[string]string{"token": filepath.Join(dir, "token")},
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if args := strings.Join(r.dockerBuildArgs(r.containerBuildImage, r.config.Dockerfile), " "); !strings.Contains(args, "--secret id=token,src="+filepath.Join(dir, "token")+" ") {
+		t.Errorf("expected --secret in %q", args)
+	}
+	if got := envValue(r.dockerBuildEnv(), "DOCKER_BUILDKIT"); got != "1" {
+		t.Errorf("expected DOCKER_BUILDKIT=1, got %q", got)
+	}
+
+	r.config.BuildSecrets["missing"] = filepath.Join(dir, "missing")
+	if err := r.Validate(); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("expected error for missing secret file, got: %v", err)
+	}
+}
+
+func TestDockerBuildArgsPassesGOFLAGS(t *testing.T) {
+	t.Setenv("GOFLAGS", "")
+	r, err := NewRunner(RunnerConfig{
+		Dockerfile: "Dockerfile",
+		BuildEnv: map[string]string{
+			"GOFLAGS": "-mod=mod",
+			"GOOS":    "darwin",
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+
+	env := r.dockerBuildEnv()
+	if got := envValue(env, "GOFLAGS"); got != "-mod=mod" {
+		t.Errorf("expected GOFLAGS=-mod=mod, got %q", got)
+	}
+	if got := envValue(env, "GOOS"); got != "linux" {
+		t.Errorf("expected GOOS to be forced to linux, got %q", got)
+	}
+
+	args := strings.Join(r.dockerBuildArgs(r.containerBuildImage, r.config.Dockerfile), " ")
+	if !strings.Contains(args, "--build-arg GOFLAGS ") {
+		t.Errorf("expected GOFLAGS build arg in %q", args)
+	}
+	if strings.Contains(args, "--build-arg GOOS") {
+		t.Errorf("unexpected GOOS build arg in %q", args)
+	}
+}
+
+func TestBuildTestBinaryPassesGOFLAGS(t *testing.T) {
+	t.Setenv("GOFLAGS", "-mod=readonly")
+	bin := t.TempDir()
+	// The fake go records the environment it was run with and fails unless
+	// the module's custom GOFLAGS are set, like a build needing -mod=mod.
+	writeTestFile(t, bin, "go", `#!/bin/sh
+echo "GOFLAGS=$GOFLAGS GOOS=$GOOS CGO_ENABLED=$CGO_ENABLED" > `+bin+`/env
+[ "$GOFLAGS" = "-mod=mod" ] || { echo "missing go.sum entry" >&2; exit 1; }
+`)
+	if err := os.Chmod(filepath.Join(bin, "go"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r, err := NewRunner(RunnerConfig{
+		Dockerfile: "Dockerfile",
+		TestDir:    t.TempDir(),
+		TmpDir:     t.TempDir(),
+		BuildEnv:   map[string]string{"GOFLAGS": "-mod=mod", "GOOS": "darwin"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if err := r.buildTestBinary(); err != nil {
+		t.Fatalf("expected the build to get the custom GOFLAGS: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(bin, "env")); err != nil || string(data) != "GOFLAGS=-mod=mod GOOS=linux CGO_ENABLED=0\n" {
+		t.Errorf("unexpected build environment %q: %v", data, err)
+	}
+
+	r.config.BuildEnv = nil
+	var buildErr *BuildError
+	if err := r.buildTestBinary(); !errors.As(err, &buildErr) || !strings.Contains(buildErr.Output, "missing go.sum entry") {
+		t.Errorf("expected the build to fail with the host GOFLAGS, got %v", err)
+	}
+}
+
+func TestRaceParallelism(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	bin := t.TempDir()
+	writeTestFile(t, bin, "docker", "#!/bin/sh\n")
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	writeTestFile(t, dir, "go.mod", "module example.com/a\n\ngo 1.24\n")
+	writeTestFile(t, dir, "Dockerfile", "FROM scratch\n")
+	r, err := NewRunner(RunnerConfig{
+		Dockerfile:      filepath.Join(dir, "Dockerfile"),
+		TestDir:         dir,
+		Race:            true,
+		Parallelism:     8,
+		SkipDockerCheck: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	// Setup may run several times, e.g. with RunWithRetry or -watch, and
+	// halves the configured parallelism each time.
+	for range 2 {
+		if err := r.Setup(); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		r.Cleanup()
+		if r.testParallelism() != 4 || r.config.Parallelism != 8 {
+			t.Errorf("expected parallelism 4 with parallelism 8 configured, got %d and %d", r.testParallelism(), r.config.Parallelism)
+		}
+	}
+}
+
+func TestIsRetryableBuildOutput(t *testing.T) {
+	if !isRetryableBuildOutput("failed to fetch: net/http: TLS handshake timeout") {
+		t.Errorf("expected TLS handshake timeout to be retryable")
+	}
+	if isRetryableBuildOutput("dockerfile parse error line 3: unknown instruction: FORM") {
+		t.Errorf("expected Dockerfile syntax error not to be retryable")
+	}
+}
+
+func TestOrderTests(t *testing.T) {
+	tests := []string{"TestC", "TestA", "TestB"}
+	orderTests(tests, OrderAlpha, 0)
+	if strings.Join(tests, ",") != "TestA,TestB,TestC" {
+		t.Errorf("expected alpha order, got %v", tests)
+	}
+
+	a := []string{"TestA", "TestB", "TestC", "TestD", "TestE"}
+	b := append([]string(nil), a...)
+	orderTests(a, OrderRandom, 42)
+	if seed := orderTests(b, OrderRandom, 42); seed != 42 {
+		t.Errorf("expected seed 42, got %d", seed)
+	}
+	if strings.Join(a, ",") != strings.Join(b, ",") {
+		t.Errorf("expected same order for same seed, got %v and %v", a, b)
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", Shuffle: true, TTY: new(bool)})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.seed = 42
+	r.testTimings = make(map[string]time.Duration)
+	if args := strings.Join(r.dockerRunArgs("TestA", "e2e-TestA"), " "); !strings.Contains(args, " -test.run ^TestA$ -test.shuffle=42") {
+		t.Errorf("expected -test.shuffle=42 in docker run args %q", args)
+	}
+	r.execContainer, r.execBinary = "dev", "/bin/e2e.test"
+	if args := strings.Join(r.dockerExecArgs("TestA"), " "); !strings.HasSuffix(args, " -test.run ^TestA$ -test.shuffle=42") {
+		t.Errorf("expected -test.shuffle=42 in docker exec args %q", args)
+	}
+	out := captureStdout(t, func() { r.printSummary(time.Second) })
+	if !strings.Contains(out, "--- INFO: Tests ran with -test.shuffle=42; replay the order with -shuffle -seed 42\n") {
+		t.Errorf("expected the shuffle seed in the summary, got %q", out)
+	}
+
+	r.config.Shuffle = false
+	if args := strings.Join(r.dockerExecArgs("TestA"), " "); strings.Contains(args, "-test.shuffle") {
+		t.Errorf("unexpected -test.shuffle without Shuffle in %q", args)
+	}
+}
+
+func TestExitCodePolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		result TestsFailedError
+		fails  bool
+	}{
+		{"", TestsFailedError{Failed: 1}, true},
+		{"any-failure", TestsFailedError{Failed: 1}, true},
+		{"threshold:2", TestsFailedError{Failed: 2}, false},
+		{"threshold:2", TestsFailedError{Failed: 3}, true},
+		{"ignore-incomplete", TestsFailedError{Failed: 2, Cancelled: 2}, false},
+		{"ignore-incomplete", TestsFailedError{Failed: 2, Cancelled: 1}, true},
+	}
+	for _, tt := range tests {
+		policy, err := ParseExitCodePolicy(tt.policy)
+		if err != nil {
+			t.Fatalf("failed to parse policy %q: %v", tt.policy, err)
+		}
+		if got := policy.Fails(&tt.result); got != tt.fails {
+			t.Errorf("policy %q with %+v: expected fails=%v, got %v", tt.policy, tt.result, tt.fails, got)
+		}
+	}
+
+	for _, invalid := range []string{"threshold", "threshold:-1", "threshold:x", "never"} {
+		if _, err := ParseExitCodePolicy(invalid); err == nil {
+			t.Errorf("expected error for policy %q", invalid)
+		}
+	}
+}
+
+func TestWaitForDependencies(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	r := &Runner{config: RunnerConfig{WaitFor: []string{ln.Addr().String()}, WaitForTimeout: time.Second}}
+	if err := r.waitForDependencies(); err != nil {
+		t.Errorf("expected listening endpoint to be ready, got: %v", err)
+	}
+
+	addr := ln.Addr().String()
+	ln.Close()
+	r.config.WaitFor = []string{addr}
+	err = r.waitForDependencies()
+	if err == nil || !strings.Contains(err.Error(), addr) {
+		t.Errorf("expected error naming %s, got: %v", addr, err)
+	}
+}
+
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
+
+// captureStdout returns what fn prints to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	var out bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		out.ReadFrom(r)
+		close(done)
+	}()
+	fn()
+	w.Close()
+	<-done
+	return out.String()
+}
+
+func TestGetTestsToRunSkipPattern(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a_test.go", `package a
+
+import "testing"
+
+func TestAPI(t *testing.T)           {}
+func TestMigrationUp(t *testing.T)   {}
+func TestMigrationDown(t *testing.T) {}
+`)
+
+	r, err := NewRunner(RunnerConfig{
+		TestDir:     dir,
+		Dockerfile:  "Dockerfile",
+		TestPattern: "Test",
+		SkipPattern: "^TestMigration",
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	tests, err := r.getTestsToRun()
+	if err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	if strings.Join(tests, ",") != "TestAPI" {
+		t.Errorf("expected only TestAPI, got %v", tests)
+	}
+	if len(r.skippedTests) != 2 {
+		t.Errorf("expected 2 skipped tests, got %v", r.skippedTests)
+	}
+
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", SkipPattern: "("}); err == nil {
+		t.Errorf("expected error for invalid skip pattern")
+	}
+}
+
+func TestGetTestsToRunExcludedLogged(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a_test.go", `package a
+
+import "testing"
+
+func TestMain(m *testing.M)        {}
+func TestAPI(t *testing.T)         {}
+func TestMigrationUp(t *testing.T) {}
+`)
+
+	// Exclusions are explained at verbosity > 1 without changing which
+	// tests are selected; TestMain is never a test.
+	r, err := NewRunner(RunnerConfig{
+		TestDir:     dir,
+		Dockerfile:  "Dockerfile",
+		TestPattern: "API",
+		Verbosity:   2,
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	var tests []string
+	out := captureStdout(t, func() {
+		tests, err = r.getTestsToRun()
+	})
+	if err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	if strings.Join(tests, ",") != "TestAPI" {
+		t.Errorf("expected only TestAPI, got %v", tests)
+	}
+	if len(r.skippedTests) != 0 {
+		t.Errorf("expected no skipped tests, got %v", r.skippedTests)
+	}
+	file := filepath.Join(dir, "a_test.go")
+	for _, line := range []string{
+		"--- DEBUG: Excluding TestMain in " + file + ": TestMain is not a test\n",
+		"--- DEBUG: Excluding TestMigrationUp in " + file + ": does not match run pattern \"API\"\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in output, got %q", line, out)
+		}
+	}
+	if strings.Contains(out, "Excluding TestAPI") {
+		t.Errorf("unexpected exclusion of TestAPI in output %q", out)
+	}
+
+	// Exclusions are not logged at lower verbosity.
+	r.config.Verbosity = 1
+	if out := captureStdout(t, func() { r.getTestsToRun() }); strings.Contains(out, "Excluding") {
+		t.Errorf("unexpected exclusions logged at verbosity 1: %q", out)
+	}
+}
+
+func TestExportTimings(t *testing.T) {
+	r := &Runner{testTimings: map[string]time.Duration{
+		"TestA": 1500 * time.Millisecond,
+		"TestB": 250 * time.Millisecond,
+	}}
+	path := filepath.Join(t.TempDir(), "timings.json")
+	if err := r.exportTimings(path); err != nil {
+		t.Fatalf("failed to export timings: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read timings: %v", err)
+	}
+	want := "{\n  \"TestA\": 1.5,\n  \"TestB\": 0.25\n}\n"
+	if string(data) != want {
+		t.Errorf("expected %q, got %q", want, data)
+	}
+}
+
+func TestGetTestsToRunChangedSince(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+	}
+	for _, pkg := range []string{"a", "b", "c", "d"} {
+		if err := os.Mkdir(filepath.Join(dir, pkg), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		writeTestFile(t, filepath.Join(dir, pkg), pkg+"_test.go", "package "+pkg+"\n\nimport \"testing\"\n\nfunc Test"+strings.ToUpper(pkg)+"(t *testing.T) {}\n")
+	}
+	writeTestFile(t, dir, ".gitignore", "ignored.go\n")
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	writeTestFile(t, filepath.Join(dir, "b"), "b.go", "package b\n")
+	git("add", ".")
+	// Untracked files count as changes, unless ignored.
+	writeTestFile(t, filepath.Join(dir, "c"), "c.go", "package c\n")
+	writeTestFile(t, filepath.Join(dir, "d"), "ignored.go", "package d\n")
+
+	r, err := NewRunner(RunnerConfig{TestDir: dir, Dockerfile: "Dockerfile", ChangedSince: "HEAD"})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	tests, err := r.getTestsToRun()
+	if err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	if strings.Join(tests, ",") != "TestB,TestC" {
+		t.Errorf("expected only TestB and TestC, got %v", tests)
+	}
+
+	r.config.ChangedSince = "no-such-ref"
+	if _, err := r.getTestsToRun(); err == nil || !strings.Contains(err.Error(), "no-such-ref") {
+		t.Errorf("expected an error for an unknown ref, got %v", err)
+	}
+
+	// Outside a git repository, all tests run.
+	r.config.TestDir = t.TempDir()
+	writeTestFile(t, r.config.TestDir, "e_test.go", "package e\n\nimport \"testing\"\n\nfunc TestE(t *testing.T) {}\n")
+	if tests, err := r.getTestsToRun(); err != nil || strings.Join(tests, ",") != "TestE" {
+		t.Errorf("expected all tests outside a git repository, got %v: %v", tests, err)
+	}
+}
+
+func TestSlowestTests(t *testing.T) {
+	r := &Runner{testTimings: map[string]time.Duration{
+		"TestA": 1 * time.Second,
+		"TestB": 3 * time.Second,
+		"TestC": 2 * time.Second,
+	}}
+	slowest := r.slowestTests(2)
+	if len(slowest) != 2 || slowest[0].name != "TestB" || slowest[1].name != "TestC" {
+		t.Errorf("expected TestB and TestC, got %v", slowest)
+	}
+}
+
+func TestRerunFailed(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+
+	r := &Runner{config: RunnerConfig{TestDir: dir}, testsToRun: []string{"TestA", "TestB", "TestC"}}
+	if err := r.filterFailedTests(); err == nil || !strings.Contains(err.Error(), "no previous run") {
+		t.Fatalf("expected no previous run error, got: %v", err)
+	}
+
+	r.failedTests = []string{"TestB"}
+	r.incompleteTests = []string{"TestC"}
+	if err := r.saveState(); err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+
+	r = &Runner{config: RunnerConfig{TestDir: dir}, testsToRun: []string{"TestA", "TestB", "TestC"}}
+	if err := r.filterFailedTests(); err != nil {
+		t.Fatalf("failed to filter tests: %v", err)
+	}
+	if strings.Join(r.testsToRun, ",") != "TestB,TestC" {
+		t.Errorf("expected TestB and TestC, got %v", r.testsToRun)
+	}
+}
+
+func TestDiscoveryCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a_test.go", "//go:build integration\n\npackage a\n\nimport \"testing\"\n\n//go:e2e timeout: 5m\nfunc TestA(t *testing.T) {}\n")
+
+	r := &Runner{config: RunnerConfig{TestDir: dir}}
+	tests, err := r.discoverTests()
+	if err != nil {
+		t.Fatalf("failed to discover tests: %v", err)
+	}
+	if len(tests) != 1 || tests[0].Name != "TestA" || tests[0].BuildConstraint != "integration" {
+		t.Fatalf("expected TestA with build constraint, got %+v", tests)
+	}
+
+	// Unchanged files are read from the cache, including their annotations.
+	files := r.loadDiscoveryCache()
+	path, err := filepath.Abs(filepath.Join(dir, "a_test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, ok := files[path]
+	if !ok || len(file.Tests) != 1 {
+		t.Fatalf("expected a_test.go to be cached, got %+v", files)
+	}
+	file.Tests[0].Name = "TestCached"
+	files[path] = file
+	if err := r.saveDiscoveryCache(files); err != nil {
+		t.Fatalf("failed to save discovery cache: %v", err)
+	}
+	r = &Runner{config: RunnerConfig{TestDir: dir}}
+	if tests, err = r.discoverTests(); err != nil {
+		t.Fatalf("failed to discover tests: %v", err)
+	}
+	if len(tests) != 1 || tests[0].Name != "TestCached" || r.testTimeouts["TestCached"] != 5*time.Minute {
+		t.Errorf("expected TestCached from the cache with its timeout, got %+v, %v", tests, r.testTimeouts)
+	}
+
+	// The cache is not used with NoDiscoveryCache, or for changed files.
+	r = &Runner{config: RunnerConfig{TestDir: dir, NoDiscoveryCache: true}}
+	if tests, err = r.discoverTests(); err != nil || len(tests) != 1 || tests[0].Name != "TestA" {
+		t.Errorf("expected TestA with the cache disabled, got %+v, %v", tests, err)
+	}
+	writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\nfunc TestB(t *testing.T) {}\n")
+	r = &Runner{config: RunnerConfig{TestDir: dir}}
+	if tests, err = r.discoverTests(); err != nil || len(tests) != 1 || tests[0].Name != "TestB" {
+		t.Errorf("expected TestB after the file changed, got %+v, %v", tests, err)
+	}
+}
+
+func TestDiscoveryCacheMerged(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n")
+	writeTestFile(t, dir, "b_test.go", "package a\n\nimport \"testing\"\n\nfunc TestB(t *testing.T) {}\n")
+
+	// Runs discovering different files keep each other's entries.
+	for _, file := range []string{"a_test.go", "b_test.go"} {
+		r := &Runner{config: RunnerConfig{TestDir: dir, TestFiles: []string{file}}}
+		if _, err := r.discoverTests(); err != nil {
+			t.Fatalf("failed to discover tests: %v", err)
+		}
+	}
+	r := &Runner{config: RunnerConfig{TestDir: dir}}
+	if files := r.loadDiscoveryCache(); len(files) != 2 {
+		t.Errorf("expected both files to be cached, got %+v", files)
+	}
+
+	// Deleted files are dropped on the next save.
+	if err := os.Remove(filepath.Join(dir, "b_test.go")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "c_test.go", "package a\n\nimport \"testing\"\n\nfunc TestC(t *testing.T) {}\n")
+	if _, err := r.discoverTests(); err != nil {
+		t.Fatalf("failed to discover tests: %v", err)
+	}
+	files := r.loadDiscoveryCache()
+	if _, ok := files[filepath.Join(dir, "b_test.go")]; ok || len(files) != 2 {
+		t.Errorf("expected a_test.go and c_test.go to be cached, got %+v", files)
+	}
+
+	// The cache is written through a temporary file that is renamed.
+	path, err := discoveryCacheFilePath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Errorf("expected no temporary files left, found %s", entry.Name())
+		}
+	}
+}
+
+func TestStateSavedWhenBundleFails(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	bin := t.TempDir()
+	writeTestFile(t, bin, "docker", "#!/bin/sh\nexit 1\n")
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	r, err := NewRunner(RunnerConfig{
+		Dockerfile: "Dockerfile",
+		TestDir:    dir,
+		TTY:        new(bool),
+		BundlePath: filepath.Join(dir, "missing", "run.tar.gz"),
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.testsToRun = []string{"TestA"}
+	if err := r.RunTests(); err == nil {
+		t.Fatalf("expected error writing the bundle")
+	}
+	state, err := loadState(dir)
+	if err != nil {
+		t.Fatalf("expected the run state to be saved: %v", err)
+	}
+	if !slices.Equal(state.Failed, []string{"TestA"}) {
+		t.Errorf("expected TestA to be saved as failed, got %v", state.Failed)
+	}
+}
+
+func TestRunTestsContextCancelled(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	bin := t.TempDir()
+	writeTestFile(t, bin, "docker", "#!/bin/sh\n")
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var status string
+	r, err := NewRunner(RunnerConfig{
+		Dockerfile: "Dockerfile",
+		TestDir:    t.TempDir(),
+		TTY:        new(bool),
+		OnEvent: func(e Event) {
+			if e.Type == EventSuiteDone {
+				status = e.Status
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.testsToRun = []string{"TestA", "TestB"}
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	err = r.RunTestsContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the run to fail with context.Canceled, got %v", err)
+	}
+	var failed *TestsFailedError
+	if !errors.As(err, &failed) || failed.Incomplete != 2 || failed.Failed != 0 {
+		t.Errorf("expected 2 incomplete tests, got %+v", failed)
+	}
+	if policy, _ := ParseExitCodePolicy(ExitCodePolicyAnyFailure); !policy.Fails(failed) {
+		t.Errorf("expected a cancelled run to fail with the default exit code policy")
+	}
+	if status != "STOP" {
+		t.Errorf("expected suite status STOP, got %q", status)
+	}
+	if r.passed {
+		t.Errorf("expected a cancelled run not to pass")
+	}
+}
+
+func TestRunTestsContextKillsRunningTests(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	bin := t.TempDir()
+	// docker run blocks until docker rm kills it, like a container would.
+	writeTestFile(t, bin, "docker", `#!/bin/sh
+case "$1" in
+run)
+	echo $$ > `+bin+`/"$3".pid
+	exec sleep 30
+	;;
+rm)
+	echo "$3" >> `+bin+`/removed
+	kill $(cat `+bin+`/"$3".pid)
+	;;
+esac
+`)
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	r, err := NewRunner(RunnerConfig{
+		Dockerfile: "Dockerfile",
+		TestDir:    t.TempDir(),
+		TTY:        new(bool),
+		NoParallel: true,
+		OnEvent: func(e Event) {
+			if e.Type == EventTestStart {
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					cancel()
+				}()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.testsToRun = []string{"TestA", "TestB"}
+	start := time.Now()
+	err = r.RunTestsContext(ctx)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the running test to be killed, took %s", elapsed)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the run to fail with context.Canceled, got %v", err)
+	}
+	var failed *TestsFailedError
+	if !errors.As(err, &failed) || failed.Failed != 1 || failed.Cancelled != 1 || failed.Incomplete != 1 {
+		t.Errorf("expected TestA cancelled and TestB incomplete, got %+v", failed)
+	}
+	if data, err := os.ReadFile(filepath.Join(bin, "removed")); err != nil || !strings.HasPrefix(string(data), "e2e-TestA-") {
+		t.Errorf("expected the container of TestA to be removed, got %q: %v", data, err)
+	}
+}
+
+func TestRunTestsContextStopsRunningTests(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	bin := t.TempDir()
+	// docker run blocks until docker stop kills it, and every other docker
+	// command is recorded in order.
+	writeTestFile(t, bin, "docker", `#!/bin/sh
+case "$1" in
+run)
+	echo $$ > `+bin+`/run.pid
+	exec sleep 30
+	;;
+stop)
+	echo "$@" >> `+bin+`/calls
+	kill $(cat `+bin+`/run.pid)
+	;;
+*)
+	echo "$@" >> `+bin+`/calls
+	;;
+esac
+`)
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	r, err := NewRunner(RunnerConfig{
+		Dockerfile:  "Dockerfile",
+		TestDir:     t.TempDir(),
+		TTY:         new(bool),
+		NoParallel:  true,
+		StopSignal:  "SIGINT",
+		StopTimeout: 3 * time.Second,
+		OnEvent: func(e Event) {
+			if e.Type == EventTestStart {
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					cancel()
+				}()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.testsToRun = []string{"TestA"}
+	if err := r.RunTestsContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the run to fail with context.Canceled, got %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(bin, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(calls) != 2 || !strings.HasPrefix(calls[0], "stop --signal SIGINT --time 3 e2e-TestA-") || !strings.HasPrefix(calls[1], "rm --force e2e-TestA-") {
+		t.Errorf("expected docker stop with the stop signal and timeout before docker rm, got %q", calls)
+	}
+}
+
+func TestMountBinarySubpackage(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n")
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "sub"), "b_test.go", "package sub\n\nimport \"testing\"\n\nfunc TestB(t *testing.T) {}\n")
+
+	r := &Runner{config: RunnerConfig{TestDir: dir, MountBinary: true}}
+	var err error
+	if r.testsToRun, err = r.getTestsToRun(); err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	if err := r.checkMountBinaryTests(); err == nil || !strings.Contains(err.Error(), "TestB") {
+		t.Errorf("expected error for TestB in a subpackage, got %v", err)
+	}
+
+	r = &Runner{config: RunnerConfig{TestDir: dir, MountBinary: true, ExcludeDirs: []string{"sub"}}}
+	if r.testsToRun, err = r.getTestsToRun(); err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	if err := r.checkMountBinaryTests(); err != nil {
+		t.Errorf("unexpected error for tests in the root package: %v", err)
+	}
+}
+
+func TestNewRunnerValidatesUser(t *testing.T) {
+	for _, user := range []string{"1000", "1000:1000", "nobody", "app:staff"} {
+		if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", User: user}); err != nil {
+			t.Errorf("expected user %q to be valid, got: %v", user, err)
+		}
+	}
+	for _, user := range []string{"1000:", ":1000", "a b", "1000:1000:1000"} {
+		if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", User: user}); err == nil {
+			t.Errorf("expected user %q to be invalid", user)
+		}
+	}
+}
+
+func TestAutoBuildTags(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "e2e_test.go", "//go:build e2e && (postgres || mysql)\n\npackage a\n\nimport \"testing\"\n\nfunc TestE2E(t *testing.T) {}\n")
+	writeTestFile(t, dir, "unit_test.go", "//go:build !e2e && linux\n\npackage a\n\nimport \"testing\"\n\nfunc TestUnit(t *testing.T) {}\n")
+	writeTestFile(t, dir, "plain_test.go", "package a\n\nimport \"testing\"\n\nfunc TestPlain(t *testing.T) {}\n")
+
+	r, err := NewRunner(RunnerConfig{TestDir: dir, Dockerfile: "Dockerfile", AutoBuildTags: true})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if err := r.resolveBuildTags(); err != nil {
+		t.Fatalf("failed to resolve build tags: %v", err)
+	}
+	if strings.Join(r.buildTags, ",") != "e2e,mysql,postgres" {
+		t.Errorf("expected tags e2e,mysql,postgres, got %v", r.buildTags)
+	}
+
+	tests, err := r.getTestsToRun()
+	if err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	if strings.Join(tests, ",") != "TestE2E,TestPlain" {
+		t.Errorf("expected TestE2E and TestPlain, got %v", tests)
+	}
+}
+
+func TestAutoBuildTagsPlatform(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "arm_test.go", "//go:build e2e && arm64 && cgo\n\npackage a\n\nimport \"testing\"\n\nfunc TestArm(t *testing.T) {}\n")
+	writeTestFile(t, dir, "amd_test.go", "//go:build e2e && amd64\n\npackage a\n\nimport \"testing\"\n\nfunc TestAmd(t *testing.T) {}\n")
+
+	for _, tc := range []struct {
+		config RunnerConfig
+		want   string
+	}{
+		{RunnerConfig{}, "TestAmd"},
+		{RunnerConfig{BuildPlatform: "linux/arm64"}, ""},
+		{RunnerConfig{BuildPlatform: "linux/arm64", Race: true}, "TestArm"},
+		{RunnerConfig{BuildPlatform: "linux/arm64", BuildEnv: map[string]string{"CGO_ENABLED": "1"}}, "TestArm"},
+	} {
+		tc.config.TestDir, tc.config.Dockerfile, tc.config.AutoBuildTags = dir, "Dockerfile", true
+		r, err := NewRunner(tc.config)
+		if err != nil {
+			t.Fatalf("failed to create runner: %v", err)
+		}
+		if err := r.resolveBuildTags(); err != nil {
+			t.Fatalf("failed to resolve build tags: %v", err)
+		}
+		if strings.Join(r.buildTags, ",") != "e2e" {
+			t.Errorf("expected only the e2e tag, got %v", r.buildTags)
+		}
+		tests, err := r.getTestsToRun()
+		if err != nil {
+			t.Fatalf("failed to get tests: %v", err)
+		}
+		if strings.Join(tests, ",") != tc.want {
+			t.Errorf("%s race=%v: expected %q, got %v", tc.config.BuildPlatform, tc.config.Race, tc.want, tests)
+		}
+	}
+}
+
+func TestContainerLabels(t *testing.T) {
+	r := &Runner{config: RunnerConfig{Labels: map[string]string{"team": "infra", "ci.job": "123"}}, runID: "abc"}
+	got := strings.Join(labelArgs(r.containerLabels("TestA")), " ")
+	want := "--label ci.job=123 --label e2e.run=abc --label e2e.test=TestA --label team=infra"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestDockerBuildArgsPassesBuildTags(t *testing.T) {
+	r, err := NewRunner(RunnerConfig{
+		TestDir:    "../examples/simple-passing",
+		Dockerfile: "Dockerfile",
+		BuildTags:  []string{"e2e", "slow"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if err := r.resolveBuildTags(); err != nil {
+		t.Fatalf("failed to resolve build tags: %v", err)
+	}
+	args := strings.Join(r.dockerBuildArgs(r.containerBuildImage, r.config.Dockerfile), " ")
+	if !strings.Contains(args, "--build-arg BUILD_TAGS=e2e,slow ") {
+		t.Errorf("expected BUILD_TAGS build arg in %q", args)
+	}
+
+	// The example Dockerfile must forward BUILD_TAGS to go test -c.
+	dockerfile, err := os.ReadFile("../examples/simple-passing/Dockerfile")
+	if err != nil {
+		t.Fatalf("failed to read example Dockerfile: %v", err)
+	}
+	if !strings.Contains(string(dockerfile), "ARG BUILD_TAGS") || !strings.Contains(string(dockerfile), `-tags "$BUILD_TAGS"`) {
+		t.Errorf("expected example Dockerfile to forward BUILD_TAGS to go test -c")
+	}
+}
+
+func TestTestFixtures(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "fixtures", "TestA"), 0755); err != nil {
+		t.Fatalf("failed to create fixtures dir: %v", err)
+	}
+
+	r := &Runner{config: RunnerConfig{TestDir: dir}, testsToRun: []string{"TestA", "TestB"}}
+	fixtures, err := r.findTestFixtures()
+	if err != nil {
+		t.Fatalf("failed to find fixtures: %v", err)
+	}
+	r.testFixtures = fixtures
+
+	want := "-v " + filepath.Join(dir, "fixtures", "TestA") + ":/fixtures:ro -e E2E_FIXTURES_DIR=/fixtures"
+	if got := strings.Join(r.fixtureArgs("TestA"), " "); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if args := r.fixtureArgs("TestB"); args != nil {
+		t.Errorf("expected no fixture args for TestB, got %v", args)
+	}
+}
+
+func TestTestFixturesMatrix(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "fixtures", "TestA"), 0755); err != nil {
+		t.Fatalf("failed to create fixtures dir: %v", err)
+	}
+
+	r := &Runner{config: RunnerConfig{TestDir: dir, Matrix: map[string][]string{"DB": {"postgres", "mysql"}}}, testsToRun: []string{"TestA"}, containerBuildImage: "image"}
+	r.expandTestsForMatrix()
+	fixtures, err := r.findTestFixtures()
+	if err != nil {
+		t.Fatalf("failed to find fixtures: %v", err)
+	}
+	r.testFixtures = fixtures
+
+	mount := filepath.Join(dir, "fixtures", "TestA") + ":/fixtures:ro"
+	for _, id := range r.testsToRun {
+		if args := strings.Join(r.dockerRunArgs(id, "name"), " "); !strings.Contains(args, mount) {
+			t.Errorf("expected fixtures of %s to be mounted in %q", id, args)
+		}
+	}
+}
+
+func TestSharedContainerAssets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "testdata"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r := &Runner{config: RunnerConfig{TestDir: dir, AssetsMountPath: "/app"}, containerBuildImage: "image"}
+	args := strings.Join(r.sharedContainerArgs("e2e-shared-x"), " ")
+	want := "--workdir /app -v " + filepath.Join(dir, "testdata") + ":/app/testdata:ro"
+	if !strings.Contains(args, want) {
+		t.Errorf("expected %q in shared container args %q", want, args)
+	}
+}
+
+func TestAssetsArgs(t *testing.T) {
+	if err := validateAssetsMountPath("testdata"); err == nil {
+		t.Errorf("expected error for relative assets mount path")
+	}
+
+	dir := t.TempDir()
+	r := &Runner{config: RunnerConfig{TestDir: dir, AssetsMountPath: "/src/pkg"}}
+	if got := strings.Join(r.assetsArgs(), " "); got != "--workdir /src/pkg" {
+		t.Errorf("expected only --workdir without a testdata dir, got %q", got)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "testdata"), 0755); err != nil {
+		t.Fatalf("failed to create testdata dir: %v", err)
+	}
+	want := "--workdir /src/pkg -v " + filepath.Join(dir, "testdata") + ":/src/pkg/testdata:ro"
+	if got := strings.Join(r.assetsArgs(), " "); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if args := strings.Join(r.dockerBuildArgs("image", "Dockerfile"), " "); !strings.Contains(args, "--build-arg ASSETS_PATH=/src/pkg ") {
+		t.Errorf("expected ASSETS_PATH build arg, got %q", args)
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	matrix := map[string][]string{"PG_VERSION": {"13", "14"}, "DB": {"postgres"}}
+	if err := validateMatrix(matrix); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := validateMatrix(map[string][]string{"DB": nil}); err == nil {
+		t.Errorf("expected error for matrix variable without values")
+	}
+
+	r := &Runner{config: RunnerConfig{Matrix: matrix}, containerBuildImage: "image", testsToRun: []string{"TestA", "TestB"}}
+	r.expandTestsForMatrix()
+	want := []string{
+		"TestA [DB=postgres,PG_VERSION=13]",
+		"TestB [DB=postgres,PG_VERSION=13]",
+		"TestA [DB=postgres,PG_VERSION=14]",
+		"TestB [DB=postgres,PG_VERSION=14]",
+	}
+	if fmt.Sprint(r.testsToRun) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, r.testsToRun)
+	}
+
+	args := strings.Join(r.dockerRunArgs(want[2], "name"), " ")
+	if !strings.Contains(args, "-e DB=postgres -e PG_VERSION=14") {
+		t.Errorf("expected matrix env args in %q", args)
+	}
+	if !strings.Contains(args, "-test.run ^TestA$") {
+		t.Errorf("expected test name without matrix label in %q", args)
+	}
+}
+
+func TestSinceLastPass(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	for _, pkg := range []string{"a", "c"} {
+		if err := os.Mkdir(filepath.Join(dir, pkg), 0755); err != nil {
+			t.Fatalf("failed to create package dir: %v", err)
+		}
+	}
+	writeTestFile(t, dir, "go.mod", "module example.com/e2e\n\ngo 1.24\n")
+	writeTestFile(t, filepath.Join(dir, "a"), "a_test.go", "package a\n\nfunc TestA(t *testing.T) {}\nfunc TestB(t *testing.T) {}\n")
+	writeTestFile(t, filepath.Join(dir, "c"), "c_test.go", "package c\n\nfunc TestC(t *testing.T) {}\n")
+
+	newRunner := func() *Runner {
+		r := &Runner{config: RunnerConfig{TestDir: dir, SinceLastPass: true}}
+		tests, err := r.getTestsToRun()
+		if err != nil {
+			t.Fatalf("failed to get tests: %v", err)
+		}
+		r.testsToRun = tests
+		return r
+	}
+
+	// Without a previous run all tests are run.
+	r := newRunner()
+	if err := r.filterUnchangedTests(); err != nil {
+		t.Fatalf("failed to filter tests: %v", err)
+	}
+	if strings.Join(r.testsToRun, ",") != "TestA,TestB,TestC" {
+		t.Errorf("expected all tests, got %v", r.testsToRun)
+	}
+	r.passedTests = []string{"TestA", "TestC"}
+	r.failedTests = []string{"TestB"}
+	if err := r.saveState(); err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+
+	// Only the failed test is run when nothing changed.
+	r = newRunner()
+	if err := r.filterUnchangedTests(); err != nil {
+		t.Fatalf("failed to filter tests: %v", err)
+	}
+	if strings.Join(r.testsToRun, ",") != "TestB" {
+		t.Errorf("expected TestB, got %v", r.testsToRun)
+	}
+
+	// Changing a package re-runs its tests.
+	writeTestFile(t, filepath.Join(dir, "c"), "c.go", "package c\n")
+	r = newRunner()
+	if err := r.filterUnchangedTests(); err != nil {
+		t.Fatalf("failed to filter tests: %v", err)
+	}
+	if strings.Join(r.testsToRun, ",") != "TestB,TestC" {
+		t.Errorf("expected TestB and TestC, got %v", r.testsToRun)
+	}
+}
+
+func TestSinceLastPassDependencies(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	for _, pkg := range []string{"a", "b", "c", "lib"} {
+		if err := os.Mkdir(filepath.Join(dir, pkg), 0755); err != nil {
+			t.Fatalf("failed to create package dir: %v", err)
+		}
+	}
+	writeTestFile(t, dir, "go.mod", "module example.com/e2e\n\ngo 1.24\n")
+	writeTestFile(t, filepath.Join(dir, "lib"), "lib.go", "package lib\n\nfunc F() int { return 1 }\n")
+	writeTestFile(t, filepath.Join(dir, "a"), "a_test.go", "package a\n\nimport (\n\t\"testing\"\n\n\t\"example.com/e2e/lib\"\n)\n\nfunc TestA(t *testing.T) { lib.F() }\n")
+	// TestShared is in two packages, and only changes in b.
+	writeTestFile(t, filepath.Join(dir, "b"), "b_test.go", "package b\n\nimport \"testing\"\n\nfunc TestShared(t *testing.T) {}\n")
+	writeTestFile(t, filepath.Join(dir, "c"), "c_test.go", "package c\n\nimport \"testing\"\n\nfunc TestShared(t *testing.T) {}\n")
+
+	runUnchanged := func() []string {
+		r := &Runner{config: RunnerConfig{TestDir: dir, SinceLastPass: true}}
+		tests, err := r.getTestsToRun()
+		if err != nil {
+			t.Fatalf("failed to get tests: %v", err)
+		}
+		r.testsToRun = tests
+		if err := r.filterUnchangedTests(); err != nil {
+			t.Fatalf("failed to filter tests: %v", err)
+		}
+		// Record every test as passed for the next run.
+		r.passedTests = tests
+		if err := r.saveState(); err != nil {
+			t.Fatalf("failed to save state: %v", err)
+		}
+		// TestShared is found once for each package.
+		return slices.Compact(r.testsToRun)
+	}
+
+	runUnchanged()
+	if tests := runUnchanged(); len(tests) != 0 {
+		t.Fatalf("expected no tests to run when nothing changed, got %v", tests)
+	}
+
+	// Changing a package imported by a test re-runs it.
+	writeTestFile(t, filepath.Join(dir, "lib"), "lib.go", "package lib\n\nfunc F() int { return 2 }\n")
+	if tests := runUnchanged(); strings.Join(tests, ",") != "TestA" {
+		t.Errorf("expected TestA after its dependency changed, got %v", tests)
+	}
+
+	// Changing go.sum re-runs all tests.
+	writeTestFile(t, dir, "go.sum", "example.com/dep v1.0.0 h1:abc=\n")
+	if tests := runUnchanged(); strings.Join(tests, ",") != "TestA,TestShared" {
+		t.Errorf("expected all tests after go.sum changed, got %v", tests)
+	}
+
+	// A test name in several packages is tracked per package.
+	state, err := loadState(dir)
+	if err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+	if _, ok := state.Tests["b/TestShared"]; !ok {
+		t.Errorf("expected state for TestShared in b, got %v", state.Tests)
+	}
+	writeTestFile(t, filepath.Join(dir, "b"), "b.go", "package b\n")
+	if tests := runUnchanged(); strings.Join(tests, ",") != "TestShared" {
+		t.Errorf("expected TestShared after b changed, got %v", tests)
+	}
+}
+
+func TestOnEvent(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	var events []Event
+	r, err := NewRunner(RunnerConfig{
+		Dockerfile: "Dockerfile",
+		TestDir:    t.TempDir(),
+		OnEvent:    func(e Event) { events = append(events, e) },
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if err := r.RunTests(); err != nil {
+		t.Fatalf("failed to run tests: %v", err)
+	}
+	if len(events) != 2 || events[0].Type != EventRunStart || events[1].Type != EventSuiteDone || events[1].Status != "PASS" {
+		t.Errorf("expected run-start and passing suite-done events, got %v", events)
+	}
+}
+
+func TestOnEventDoesNotBlockTests(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	bin := t.TempDir()
+	ran := filepath.Join(bin, "ran")
+	writeTestFile(t, bin, "docker", "#!/bin/sh\ntouch "+ran+"\n")
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// The callback blocks on the run-start event until the test has run,
+	// which it only can if events are delivered asynchronously.
+	var events []EventType
+	blocked := true
+	r, err := NewRunner(RunnerConfig{
+		Dockerfile: "Dockerfile",
+		TestDir:    t.TempDir(),
+		TTY:        new(bool),
+		OnEvent: func(e Event) {
+			if e.Type == EventRunStart {
+				for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+					if _, err := os.Stat(ran); err == nil {
+						blocked = false
+						break
+					}
+				}
+			}
+			events = append(events, e.Type)
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.testsToRun = []string{"TestA"}
+	if err := r.RunTests(); err != nil {
+		t.Fatalf("failed to run tests: %v", err)
+	}
+	if blocked {
+		t.Errorf("expected the test to run while the callback was blocked")
+	}
+	want := []EventType{EventRunStart, EventTestStart, EventTestFinish, EventSuiteDone}
+	if !slices.Equal(events, want) {
+		t.Errorf("expected events %v delivered in order before RunTests returned, got %v", want, events)
+	}
+}
+
+func TestDNS(t *testing.T) {
+	if err := validateDNS([]string{"10.0.0.53", "fd00::53"}, []string{"corp.example.com"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateDNS([]string{"dns.example.com"}, nil); err == nil {
+		t.Errorf("expected error for dns server that is not an IP address")
+	}
+	if err := validateDNS(nil, []string{"corp example"}); err == nil {
+		t.Errorf("expected error for invalid dns-search domain")
+	}
+
+	r := &Runner{config: RunnerConfig{DNS: []string{"10.0.0.53"}, DNSSearch: []string{"corp.example.com"}}, containerBuildImage: "image"}
+	if args := strings.Join(r.dockerRunArgs("TestA", "name"), " "); !strings.Contains(args, "--dns 10.0.0.53 --dns-search corp.example.com") {
+		t.Errorf("expected --dns and --dns-search in %q", args)
+	}
+}
+
+func TestAddHosts(t *testing.T) {
+	for _, host := range []string{"db.local:10.0.0.1", "db.local:::1", "host.docker.internal:host-gateway"} {
+		if err := validateAddHosts([]string{host}); err != nil {
+			t.Errorf("expected %q to be valid, got: %v", host, err)
+		}
+	}
+	for _, host := range []string{"db.local", ":10.0.0.1", "db.local:not-an-ip"} {
+		if err := validateAddHosts([]string{host}); err == nil {
+			t.Errorf("expected %q to be invalid", host)
+		}
+	}
+
+	r := &Runner{config: RunnerConfig{AddHosts: []string{"db.local:10.0.0.1"}}, containerBuildImage: "image"}
+	if args := strings.Join(r.dockerRunArgs("TestA", "name"), " "); !strings.Contains(args, "--add-host db.local:10.0.0.1") {
+		t.Errorf("expected --add-host in %q", args)
+	}
+}
+
+func TestSplitShellWords(t *testing.T) {
+	for in, want := range map[string][]string{
+		`--network host`:                    {"--network", "host"},
+		`-e MSG="hello world"`:              {"-e", "MSG=hello world"},
+		`-v '/data/my files:/data'`:         {"-v", "/data/my files:/data"},
+		`-v /data/my\ files:/data`:          {"-v", "/data/my files:/data"},
+		`-e "QUOTE=say \"hi\"" -e EMPTY=""`: {"-e", `QUOTE=say "hi"`, "-e", "EMPTY="},
+		`  `:                                nil,
+	} {
+		got, err := splitShellWords(in)
+		if err != nil {
+			t.Errorf("failed to split %q: %v", in, err)
+			continue
+		}
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+			t.Errorf("split %q: expected %q, got %q", in, want, got)
+		}
+	}
+	for _, in := range []string{`-e MSG="hello`, `-v 'a`, `a\`} {
+		if _, err := splitShellWords(in); err == nil {
+			t.Errorf("expected error splitting %q", in)
+		}
+	}
+
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", DockerRunArgs: []string{`-e MSG="hello world"`}})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	args := r.dockerRunArgs("TestA", "name")
+	if !slices.Contains(args, "MSG=hello world") {
+		t.Errorf("expected quoted arg to be passed as one argument, got %q", args)
+	}
+}
+
+func TestRunStats(t *testing.T) {
+	r := &Runner{
+		config:      RunnerConfig{Parallelism: 4},
+		testsToRun:  []string{"TestA", "TestB", "TestC", "TestD"},
+		passedTests: []string{"TestA", "TestB", "TestC"},
+		failedTests: []string{"TestD"},
+		testTimings: map[string]time.Duration{
+			"TestA": 1 * time.Second,
+			"TestB": 1 * time.Second,
+			"TestC": 2 * time.Second,
+			"TestD": 2 * time.Second,
+		},
+	}
+	r.skippedTests = []string{"TestE"}
+	r.suiteDuration = 2 * time.Second
+	results := r.Results()
+	if results.Passed != 3 || results.Failed != 1 || results.Skipped != 1 || results.Duration != 2*time.Second {
+		t.Errorf("unexpected results: %+v", results)
+	}
+	if len(results.Tests) != 5 || results.Tests[3] != (TestResult{Name: "TestE", Status: "SKIP"}) || results.Tests[4] != (TestResult{Name: "TestD", Status: "FAIL", Duration: 2 * time.Second}) {
+		t.Errorf("unexpected test results: %+v", results.Tests)
+	}
+
+	stats := r.runStats(2 * time.Second)
+	if stats.passed != 3 || stats.completed != 4 || stats.testTime != 6*time.Second {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.speedup != 3 || stats.efficiency != 0.75 {
+		t.Errorf("expected 3x speedup at 75%% efficiency, got %+v", stats)
+	}
+}
+
+func TestMountBinary(t *testing.T) {
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", MountBinary: true, Entrypoint: "/bin/sh"}); err == nil {
+		t.Errorf("expected error combining mount-binary and entrypoint")
+	}
+
+	dir := t.TempDir()
+	writeTestFile(t, dir, "go.mod", "module example.com/a\n\ngo 1.24\n")
+	writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n")
+
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TestDir: dir, MountBinary: true})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.containerBuildImage = "image"
+	if err := r.buildTestBinary(); err != nil {
+		t.Fatalf("failed to build test binary: %v", err)
+	}
+	if _, err := os.Stat(r.binaryPath); err != nil {
+		t.Errorf("expected test binary: %v", err)
+	}
+	args := strings.Join(r.dockerRunArgs("TestA", "name"), " ")
+	want := "-v " + r.binaryPath + ":/e2e.test:ro --entrypoint /e2e.test"
+	if !strings.Contains(args, want) {
+		t.Errorf("expected %q in %q", want, args)
+	}
+
+	r.Cleanup()
+	if _, err := os.Stat(r.binaryPath); !os.IsNotExist(err) {
+		t.Errorf("expected test binary to be removed by Cleanup")
+	}
+}
+
+func TestTTY(t *testing.T) {
+	for _, tty := range []bool{true, false} {
+		r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TTY: &tty})
+		if err != nil {
+			t.Fatalf("failed to create runner: %v", err)
+		}
+		if got := slices.Contains(r.dockerRunArgs("TestA", "name"), "--tty"); got != tty {
+			t.Errorf("expected --tty %v, got %v", tty, got)
+		}
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	r := &Runner{config: RunnerConfig{TestDir: t.TempDir()}}
+
+	var output strings.Builder
+	if err := r.runHook("before-each", `echo "reset for $E2E_TEST"`, "TestA", &output, &output); err != nil {
+		t.Fatalf("failed to run hook: %v", err)
+	}
+	if output.String() != "reset for TestA\n" {
+		t.Errorf("expected hook output, got %q", output.String())
+	}
+
+	output.Reset()
+	err := r.runHook("after-each", "exit 3", "TestA", &output, &output)
+	if err == nil || !strings.Contains(err.Error(), "after-each command failed") {
+		t.Errorf("expected after-each failure, got: %v", err)
+	}
+	if !strings.Contains(output.String(), "after-each command failed") {
+		t.Errorf("expected failure in output, got %q", output.String())
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{max: 8}
+	b.WriteString("abc")
+	if got := b.String(); got != "abc" {
+		t.Errorf("expected %q, got %q", "abc", got)
+	}
+	b.WriteString("defghij")
+	b.WriteString("klmnop")
+	want := "abcd\n... truncated 8 bytes ...\nmnop"
+	if got := b.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// Many small writes keep the same head and tail as one large write,
+	// without the tail growing past twice its cap.
+	b = &cappedBuffer{max: 9}
+	var all strings.Builder
+	for i := range 1000 {
+		s := fmt.Sprintf("%d,", i)
+		all.WriteString(s)
+		b.WriteString(s)
+		if len(b.tail) > 2*5 {
+			t.Fatalf("expected the tail to be trimmed, got %d bytes", len(b.tail))
+		}
+	}
+	s := all.String()
+	want = fmt.Sprintf("%s\n... truncated %d bytes ...\n%s", s[:4], len(s)-9, s[len(s)-5:])
+	if got := b.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	b.WriteString(strings.Repeat("x", 20))
+	if got := b.String(); !strings.HasSuffix(got, "\nxxxxx") || !strings.Contains(got, fmt.Sprintf("truncated %d bytes", len(s)+20-9)) {
+		t.Errorf("unexpected output after a write larger than the tail: %q", got)
+	}
+
+	unlimited := &cappedBuffer{}
+	unlimited.WriteString(strings.Repeat("x", 100))
+	if got := unlimited.String(); len(got) != 100 {
+		t.Errorf("expected all output to be kept, got %d bytes", len(got))
+	}
+}
+
+func TestImageRegistryHost(t *testing.T) {
+	for image, want := range map[string]string{
+		"e2e:latest":                           "",
+		"team/e2e:latest":                      "",
+		"registry.example.com/team/e2e:latest": "registry.example.com",
+		"localhost:5000/e2e":                   "localhost:5000",
+		"localhost/e2e":                        "localhost",
+	} {
+		if got := imageRegistryHost(image); got != want {
+			t.Errorf("imageRegistryHost(%q): expected %q, got %q", image, want, got)
+		}
+	}
+
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", ImageName: "team/e2e", PushImage: true}); err == nil {
+		t.Errorf("expected error pushing an image without a registry host")
+	}
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", ImageName: "registry.example.com/e2e", PushImage: true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestTestSkipped(t *testing.T) {
+	output := "=== RUN   TestA\r\n    a_test.go:5: no database\r\n--- SKIP: TestA (0.00s)\r\nPASS\r\n"
+	if !testSkipped(output, "TestA") {
+		t.Errorf("expected TestA to be skipped")
+	}
+	if testSkipped(output, "TestAB") {
+		t.Errorf("expected TestAB not to be skipped")
+	}
+	subtest := "=== RUN   TestB\n    --- SKIP: TestB/sub (0.00s)\n--- PASS: TestB (0.00s)\n"
+	if testSkipped(subtest, "TestB") {
+		t.Errorf("expected TestB with a skipped subtest not to be skipped")
+	}
+}
+
+func TestPlatforms(t *testing.T) {
+	for _, platform := range []string{"amd64", "windows/amd64", "linux/", "linux/arm/v7/x"} {
+		if err := validatePlatforms(platform, ""); err == nil {
+			t.Errorf("expected %q to be invalid", platform)
+		}
+	}
+
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", BuildPlatform: "linux/arm64", RunPlatform: "linux/arm64"})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.containerBuildImage = "image"
+	if args := strings.Join(r.dockerBuildArgs(r.containerBuildImage, r.config.Dockerfile), " "); !strings.Contains(args, "--platform linux/arm64") {
+		t.Errorf("expected --platform in build args %q", args)
+	}
+	if args := strings.Join(r.dockerRunArgs("TestA", "name"), " "); !strings.Contains(args, "--platform linux/arm64") {
+		t.Errorf("expected --platform in run args %q", args)
+	}
+	if got := envValue(r.dockerBuildEnv(), "GOARCH"); got != "arm64" {
+		t.Errorf("expected GOARCH=arm64, got %q", got)
+	}
+	if warning := r.platformMismatch(); warning != "" {
+		t.Errorf("expected no warning, got %q", warning)
+	}
+
+	r.config.BuildPlatform = ""
+	if warning := r.platformMismatch(); !strings.Contains(warning, "linux/amd64") {
+		t.Errorf("expected mismatch warning, got %q", warning)
+	}
+}
+
+func TestSharedContainerRequiresNoParallel(t *testing.T) {
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", SharedContainer: true}); err == nil {
+		t.Errorf("expected error without no-parallel")
+	}
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", SharedContainer: true, NoParallel: true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestExecInto(t *testing.T) {
+	if _, err := NewRunner(RunnerConfig{ExecInto: "dev"}); err == nil {
+		t.Errorf("expected error without entrypoint")
+	}
+
+	r, err := NewRunner(RunnerConfig{ExecInto: "dev", Entrypoint: "/bin/e2e.test", User: "1000"})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.tty = false
+	r.execContainer, r.execBinary = "dev", "/bin/e2e.test"
+	want := "exec --user 1000 dev /bin/e2e.test -test.run ^TestA$"
+	if got := strings.Join(r.dockerExecArgs("TestA"), " "); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestSharedContainerRestartedAfterTimeout(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	bin := t.TempDir()
+	// docker exec blocks until docker restart kills the test in the container.
+	writeTestFile(t, bin, "docker", `#!/bin/sh
+case "$1" in
+exec)
+	echo $$ > `+bin+`/exec.pid
+	exec sleep 30
+	;;
+restart)
+	echo "$@" >> `+bin+`/restarted
+	kill $(cat `+bin+`/exec.pid)
+	;;
+esac
+`)
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r, err := NewRunner(RunnerConfig{
+		Dockerfile:      "Dockerfile",
+		TestDir:         t.TempDir(),
+		TTY:             new(bool),
+		NoParallel:      true,
+		SharedContainer: true,
+		TestTimeout:     100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.testsToRun = []string{"TestA"}
+	r.sharedContainer, r.execContainer, r.execBinary = "shared", "shared", "/bin/e2e.test"
+	if err := r.RunTests(); err == nil {
+		t.Fatalf("expected the timed out test to fail")
+	}
+	if data, err := os.ReadFile(filepath.Join(bin, "restarted")); err != nil || string(data) != "restart --time 0 shared\n" {
+		t.Errorf("expected the shared container to be restarted, got %q: %v", data, err)
+	}
+}
+
+func TestIdleWatcher(t *testing.T) {
+	idled := make(chan struct{})
+	w := watchIdle(50*time.Millisecond, func() { close(idled) })
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("progress"))
+	}
+	select {
+	case <-idled:
+		t.Fatalf("expected writes to reset the idle timeout")
+	default:
+	}
+	<-idled
+	if !w.Stop() {
+		t.Errorf("expected Stop to report the timeout was reached")
+	}
+
+	w = watchIdle(time.Hour, func() { t.Errorf("unexpected idle timeout") })
+	if w.Stop() {
+		t.Errorf("expected Stop to report the timeout was not reached")
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	args := []string{
+		"run", "-e", "A=1", "--env", "B=2", "--env=C=3", "-eD=4", "-e=E=5", "-e", "HOST_VAR",
+		"--env-file", "/home/me/.env", "--env-file=secrets.env", "--network", "host", "image",
+	}
+	want := "run -e A=<redacted> --env B=<redacted> --env=C=<redacted> -eD=<redacted> -e=E=<redacted> -e HOST_VAR " +
+		"--env-file <redacted> --env-file=<redacted> --network host image"
+	if got := strings.Join(redactArgs(args), " "); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if args[2] != "A=1" {
+		t.Errorf("expected the args not to be modified, got %q", args)
+	}
+}
+
+func TestWriteBundle(t *testing.T) {
+	r := &Runner{
+		config: RunnerConfig{
+			Dockerfile: "Dockerfile",
+			BuildEnv:   map[string]string{"GOPRIVATE": "secret.example.com"},
+			BundlePath: filepath.Join(t.TempDir(), "run.tar.gz"),
+			WebhookURL: "https://hooks.example.com/services/T000/B000/XXXX?token=secret",
+		},
+		runArgs:     []string{"-e", "TOKEN=secret", "--network", "host"},
+		passedTests: []string{"TestA"},
+		testTimings: map[string]time.Duration{"TestA": time.Second},
+	}
+	output := newTestOutput(0)
+	output.WriteString("ok\nwarning\n")
+	output.stdout.WriteString("ok\n")
+	output.stderr.WriteString("warning\n")
+	r.recordForBundle("TestA", []string{"run", "--env=PASSWORD=secret", "image", "-test.run", "^TestA$"}, output)
+	if err := r.writeBundle(r.config.BundlePath, time.Second); err != nil {
+		t.Fatalf("failed to write bundle: %v", err)
+	}
+
+	listing, err := exec.Command("tar", "-tzf", r.config.BundlePath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to list bundle: %v\n%s", err, listing)
+	}
+	if want := "logs/TestA.log\nlogs/TestA.stdout.log\nlogs/TestA.stderr.log\n"; !strings.HasSuffix(string(listing), want) {
+		t.Errorf("expected per-stream logs in bundle, got:\n%s", listing)
+	}
+	out, err := exec.Command("tar", "-xzOf", r.config.BundlePath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to read bundle: %v\n%s", err, out)
+	}
+	contents := string(out)
+	// Check for the secret values, as config keys such as build-secrets
+	// contain the word itself.
+	for _, secret := range []string{"secret.example.com", "TOKEN=secret", "PASSWORD=secret", "/services/T000", "token=secret"} {
+		if strings.Contains(contents, secret) {
+			t.Errorf("expected %q to be redacted from bundle:\n%s", secret, contents)
+		}
+	}
+	for _, want := range []string{"TOKEN=<redacted>", "webhook-url: https://hooks.example.com/<redacted>", "TestA: docker run --env=PASSWORD=<redacted>", `"passed": [`, "ok\nwarning\n"} {
+		if !strings.Contains(contents, want) {
+			t.Errorf("expected %q in bundle:\n%s", want, contents)
+		}
+	}
+}
+
+func TestGetTestsToRunTestFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a_test.go", "package a\n\nfunc TestA(t *testing.T) {}\nfunc TestA2(t *testing.T) {}\n")
+	writeTestFile(t, dir, "b_test.go", "package a\n\nfunc TestB(t *testing.T) {}\n")
+
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TestDir: dir, TestFiles: []string{"a_test.go"}, SkipPattern: "A2"})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	tests, err := r.getTestsToRun()
+	if err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	if strings.Join(tests, ",") != "TestA" {
+		t.Errorf("expected only TestA, got %v", tests)
+	}
+
+	for _, file := range []string{"a.go", "c_test.go"} {
+		r.config.TestFiles = []string{file}
+		if err := r.Validate(); err == nil || !strings.Contains(err.Error(), file) {
+			t.Errorf("expected error for test file %q, got: %v", file, err)
+		}
+	}
+}
+
+func TestDockerParallelismLimit(t *testing.T) {
+	for _, tt := range []struct {
+		ncpu     int
+		memTotal int64
+		want     int
+	}{
+		{ncpu: 4, memTotal: 8 << 30, want: 4},
+		{ncpu: 16, memTotal: 1 << 30, want: 4},
+		{ncpu: 2, memTotal: 100 << 20, want: 1},
+	} {
+		if got := dockerParallelismLimit(tt.ncpu, tt.memTotal); got != tt.want {
+			t.Errorf("dockerParallelismLimit(%d, %d): expected %d, got %d", tt.ncpu, tt.memTotal, tt.want, got)
+		}
+	}
+}
+
+func TestSummaryTemplate(t *testing.T) {
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", SummaryTemplate: "{{.Passed"}); err == nil {
+		t.Errorf("expected error for invalid summary template")
+	}
+
+	results := Results{Passed: 1, Failed: 1, Duration: 3 * time.Second, Tests: []TestResult{
+		{Name: "TestA", Status: "PASS", Duration: time.Second},
+		{Name: "TestB", Status: "FAIL", Duration: 2 * time.Second},
+	}}
+	for name, want := range map[string]string{
+		"markdown":       "| TestB | FAIL | 2.00s |",
+		"github-actions": "::error title=E2E test failed::TestB failed (2.00s)",
+		"{{range .Tests}}{{.Name}}={{.Status}} {{end}}": "TestA=PASS TestB=FAIL",
+	} {
+		tmpl, err := parseSummaryTemplate(name)
+		if err != nil {
+			t.Fatalf("failed to parse summary template %q: %v", name, err)
+		}
+		var out strings.Builder
+		if err := tmpl.Execute(&out, results); err != nil {
+			t.Fatalf("failed to render summary template %q: %v", name, err)
+		}
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in summary template %q output:\n%s", want, name, out.String())
+		}
+	}
+}
+
+func TestTestTimeoutAnnotation(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a_test.go", `package a
+
+import "testing"
+
+// TestSlow is slow.
+//
+//go:e2e timeout: 5m
+func TestSlow(t *testing.T) {}
+
+func TestFast(t *testing.T) {}
+`)
+
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TestDir: dir, TestTimeout: time.Minute})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if _, err := r.getTestsToRun(); err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	if got := r.testTimeout("TestSlow"); got != 5*time.Minute {
+		t.Errorf("expected annotated timeout of 5m, got %s", got)
+	}
+	if got := r.testTimeout("TestFast"); got != time.Minute {
+		t.Errorf("expected default timeout of 1m, got %s", got)
+	}
+
+	for _, annotation := range []string{"//go:e2e timeout: soon", "//go:e2e timeout: -1m", "//go:e2e timeout"} {
+		writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\n"+annotation+"\nfunc TestA(t *testing.T) {}\n")
+		if _, err := r.getTestsToRun(); err == nil {
+			t.Errorf("expected error for annotation %q", annotation)
+		}
+	}
+}
+
+func TestCleanupPolicy(t *testing.T) {
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", CleanupPolicy: "sometimes"}); err == nil {
+		t.Errorf("expected error for invalid cleanup policy")
+	}
+	for _, policy := range []string{"", CleanupAlways, CleanupOnSuccess, CleanupNever} {
+		if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", CleanupPolicy: policy}); err != nil {
+			t.Errorf("unexpected error for cleanup policy %q: %v", policy, err)
+		}
+	}
+}
+
+func TestTestFlagsFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "flags", "# Run each test twice.\n-test.count=2\n\n-test.timeout 5m\n")
+	writeTestFile(t, dir, "bad-flags", "-test.run=TestB\n")
+
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TestFlagsFile: filepath.Join(dir, "flags")})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if r.testFlags, err = readTestFlagsFile(r.config.TestFlagsFile); err != nil {
+		t.Fatalf("failed to read test flags file: %v", err)
+	}
+	args := strings.Join(r.dockerRunArgs("TestA", "name"), " ")
+	if !strings.HasSuffix(args, "-test.run ^TestA$ -test.count=2 -test.timeout 5m") {
+		t.Errorf("expected test flags after -test.run, got %q", args)
+	}
+
+	if _, err := readTestFlagsFile(filepath.Join(dir, "bad-flags")); err == nil {
+		t.Errorf("expected error for -test.run in test flags file")
+	}
+	if _, err := readTestFlagsFile(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected error for missing test flags file")
+	}
+}
+
+func TestDockerfileGroups(t *testing.T) {
+	for _, groups := range [][]DockerfileGroup{
+		{{Dockerfile: "Dockerfile.ffmpeg"}},
+		{{Dockerfile: "Dockerfile.ffmpeg", TestPattern: "("}},
+	} {
+		if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", DockerfileGroups: groups}); err == nil {
+			t.Errorf("expected error for dockerfile groups %+v", groups)
+		}
+	}
+
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", DockerfileGroups: []DockerfileGroup{
+		{Dockerfile: "Dockerfile.ffmpeg", TestPattern: "Video"},
+		{Dockerfile: "Dockerfile.imagemagick", TestPattern: "Image|Thumbnail"},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.containerBuildImage = "e2e-test-runner-abc:dev"
+	r.groupImages = []string{groupImageName(r.containerBuildImage, 0), groupImageName(r.containerBuildImage, 1)}
+	for test, want := range map[string]string{
+		"TestVideoTranscode": "e2e-test-runner-abc-group1:dev",
+		"TestThumbnail":      "e2e-test-runner-abc-group2:dev",
+		"TestHealth":         "e2e-test-runner-abc:dev",
+	} {
+		if !slices.Contains(r.dockerRunArgs(test, "name"), want) {
+			t.Errorf("expected %s to run in %s, got %q", test, want, r.dockerRunArgs(test, "name"))
+		}
+	}
+
+	if got := groupImageName("registry.example.com:5000/e2e", 0); got != "registry.example.com:5000/e2e-group1" {
+		t.Errorf("unexpected group image name %q", got)
+	}
+	if args := strings.Join(r.dockerBuildArgs(r.groupImages[1], "Dockerfile.imagemagick"), " "); !strings.HasPrefix(args, "build -t e2e-test-runner-abc-group2:dev -f Dockerfile.imagemagick ") {
+		t.Errorf("unexpected group build args %q", args)
+	}
+}
+
+func TestTimeBudget(t *testing.T) {
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TimeBudget: time.Minute})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.budgetDeadline = time.Now().Add(-time.Second)
+	r.runTest(t.Context(), "TestA", func() {})
+	if !slices.Equal(r.budgetSkippedTests, []string{"TestA"}) {
+		t.Errorf("expected TestA to be skipped for the budget, got %v", r.budgetSkippedTests)
+	}
+	if results := r.Results(); results.Skipped != 1 || results.Failed != 0 {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
+
+func TestImageLabels(t *testing.T) {
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", ImageLabels: map[string]string{"team": "media", "git-sha": "abc123"}})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	args := strings.Join(r.dockerBuildArgs("image", "Dockerfile"), " ")
+	if !strings.Contains(args, "--label e2e.built-at=") || !strings.Contains(args, " --label git-sha=abc123 --label team=media ") {
+		t.Errorf("expected sorted image labels in %q", args)
+	}
+}
+
+func TestDockerfileContent(t *testing.T) {
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", DockerfileContent: "FROM scratch\n"}); err == nil {
+		t.Errorf("expected error setting both dockerfile and dockerfile-content")
+	}
+	if _, err := NewRunner(RunnerConfig{}); err == nil {
+		t.Errorf("expected error setting neither dockerfile nor dockerfile-content")
+	}
+
+	dir := t.TempDir()
+	writeTestFile(t, dir, "go.mod", "module example.com/a\n\ngo 1.24\n")
+	r, err := NewRunner(RunnerConfig{TestDir: dir, DockerfileContent: "FROM scratch\n"})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if err := r.Validate(); err != nil {
+		t.Errorf("expected inline Dockerfile to validate without a Dockerfile file: %v", err)
+	}
+	r.config.TmpDir = t.TempDir()
+	path, err := r.writeInlineDockerfile(r.config.DockerfileContent)
+	if err != nil {
+		t.Fatalf("failed to write inline Dockerfile: %v", err)
+	}
+	if !strings.HasPrefix(path, r.config.TmpDir+string(filepath.Separator)) {
+		t.Errorf("expected inline Dockerfile in %s, got %s", r.config.TmpDir, path)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "FROM scratch\n" {
+		t.Errorf("unexpected inline Dockerfile %q: %v", data, err)
+	}
+	r.Cleanup()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected inline Dockerfile to be removed by Cleanup")
+	}
+}
+
+func TestRetryTest(t *testing.T) {
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", RetryExitCodes: []int{137}}); err == nil {
+		t.Errorf("expected error for retry-exit-codes without test-retries")
+	}
+
+	exitErr := func(code int) error {
+		return exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+	}
+	r := &Runner{config: RunnerConfig{TestRetries: 2, RetryExitCodes: []int{125, 137}}}
+	ctx := t.Context()
+	if !r.retryTest(ctx, exitErr(137), 1) || !r.retryTest(ctx, exitErr(125), 2) {
+		t.Errorf("expected retry for listed exit codes")
+	}
+	if r.retryTest(ctx, exitErr(137), 3) {
+		t.Errorf("expected no retry after the last attempt")
+	}
+	if r.retryTest(ctx, exitErr(1), 1) || r.retryTest(ctx, fmt.Errorf("timed out"), 1) {
+		t.Errorf("expected no retry for unlisted exit codes")
+	}
+
+	r.config.RetryExitCodes = nil
+	if !r.retryTest(ctx, exitErr(1), 1) {
+		t.Errorf("expected retry of any failure without retry-exit-codes")
+	}
+}
+
+func TestFindBuildDir(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"a", "b", "c"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(root, dir), "go.mod", "module example.com/"+dir+"\n")
+	}
+	writeTestFile(t, root, "go.work", "go 1.24\n\nuse (\n\t./a\n\t\"./b\" // tools\n)\n")
+
+	for dir, want := range map[string]string{"a": root, "b": root, "c": filepath.Join(root, "c")} {
+		got, err := findBuildDir(filepath.Join(root, dir))
+		if err != nil {
+			t.Fatalf("failed to find build dir of %s: %v", dir, err)
+		}
+		if got != want {
+			t.Errorf("expected build dir of %s to be %s, got %s", dir, want, got)
+		}
+	}
+
+	r, err := NewRunner(RunnerConfig{Dockerfile: filepath.Join(root, "go.work"), TestDir: filepath.Join(root, "a"), GoModDir: filepath.Join(root, "c")})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if err := r.resolveBuildDir(); err != nil || r.buildDir != filepath.Join(root, "c") {
+		t.Errorf("expected go-mod-dir to override the build dir, got %s: %v", r.buildDir, err)
+	}
+	r.config.GoModDir = t.TempDir()
+	if err := r.resolveBuildDir(); err == nil {
+		t.Errorf("expected error for go-mod-dir without go.mod or go.work")
+	}
+}
+
+func TestConfigYAML(t *testing.T) {
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", BuildSecrets: map[string]string{"netrc": "/home/me/.netrc"}})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	data, err := r.ConfigYAML()
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	config := string(data)
+	for _, want := range []string{"test-dir: .\n", "fail-fast: true\n", "slowest-n: 10\n", "netrc: <redacted>\n"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("expected %q in config:\n%s", want, config)
+		}
+	}
+}
+
+func TestHealthCheckAnnotation(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\n//go:e2e healthcheck: "+server.URL+"/health\nfunc TestServer(t *testing.T) {}\n")
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TestDir: dir})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if _, err := r.getTestsToRun(); err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	var output strings.Builder
+	if err := r.checkTestHealth(t.Context(), "TestServer", &output); err != nil {
+		t.Errorf("expected health check to pass: %v", err)
+	}
+	if err := r.checkTestHealth(t.Context(), "TestOther", &output); err != nil {
+		t.Errorf("expected no health check for unannotated test: %v", err)
+	}
+
+	healthy = false
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+	if err := r.checkTestHealth(ctx, "TestServer", &output); err == nil || !strings.Contains(output.String(), "--- ERROR: Health check") {
+		t.Errorf("expected health check to fail with a reason, got %v: %q", err, output.String())
+	}
+
+	for _, annotation := range []string{"//go:e2e healthcheck: localhost:8080", "//go:e2e retries: 3"} {
+		writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\n"+annotation+"\nfunc TestA(t *testing.T) {}\n")
+		if _, err := r.getTestsToRun(); err == nil {
+			t.Errorf("expected error for annotation %q", annotation)
+		}
+	}
+}
+
+func TestIsInfraError(t *testing.T) {
+	tests := []struct {
+		err   error
+		infra bool
+	}{
+		{fmt.Errorf("%w: exec: \"docker\": executable file not found", ErrDaemonUnavailable), true},
+		{fmt.Errorf("%w alpine\nTLS handshake timeout", errPullFailed), true},
+		{newBuildError("failed to resolve source metadata: 503 Service Unavailable", false), true},
+		{newBuildError("#12 1.234 ./a_test.go:10:2: undefined: client\nunexpected EOF", false), false},
+		{newBuildError("failed to solve: dockerfile parse error", false), false},
+		{fmt.Errorf("setup: %w", ErrDockerfileNotFound), false},
+	}
+	for _, tt := range tests {
+		if got := isInfraError(tt.err); got != tt.infra {
+			t.Errorf("isInfraError(%q) = %v, want %v", tt.err, got, tt.infra)
+		}
+	}
+}
+
+func TestBuildErrorDiagnostics(t *testing.T) {
+	output := `#12 [build 4/5] RUN go test -c -o /e2e.test ./e2e
+#12 1.234 # example.com/a/e2e
+#12 1.234 ./a_test.go:10:2: undefined: client
+#12 1.234 ./a_test.go:14:9: too many return values
+#12 1.235 b_test.go:3: missing return
+#12 ERROR: process "/bin/sh -c go test -c" did not complete successfully: exit code: 1
+`
+	err := newBuildError(output, true)
+	if len(err.Diagnostics) != 3 || err.Diagnostics[0] != (Diagnostic{File: "a_test.go", Line: 10, Column: 2, Message: "undefined: client"}) {
+		t.Fatalf("unexpected diagnostics: %+v", err.Diagnostics)
+	}
+	want := "failed to build docker image: 3 compile errors\na_test.go:\n  10:2: undefined: client\n  14:9: too many return values\nb_test.go:\n  3: missing return"
+	if err.Error() != want {
+		t.Errorf("expected error %q, got %q", want, err.Error())
+	}
+
+	if err := newBuildError("pull access denied", true); len(err.Diagnostics) != 0 || err.Error() != ErrBuildFailed.Error() {
+		t.Errorf("unexpected error without diagnostics: %q", err.Error())
+	}
+}
+
+func TestContainerLimiter(t *testing.T) {
+	limiter := NewContainerLimiter(1)
+	if !limiter.acquire(t.Context()) {
+		t.Fatalf("expected to acquire a free slot")
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if limiter.acquire(ctx) {
+		t.Errorf("expected acquire to wait for the slot until cancelled")
+	}
+	limiter.release()
+	if !limiter.acquire(t.Context()) {
+		t.Errorf("expected to acquire the released slot")
+	}
+
+	var none *ContainerLimiter
+	if !none.acquire(t.Context()) {
+		t.Errorf("expected a nil limiter not to block")
+	}
+	none.release()
+}
+
+func TestTestOutputStreams(t *testing.T) {
+	bin := t.TempDir()
+	writeTestFile(t, bin, "docker", "#!/bin/sh\necho '{\"ok\":true}'\necho 'noise' >&2\n")
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TTY: new(bool)})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	_, output, err := r.execTest(t.Context(), "TestA")
+	if err != nil {
+		t.Fatalf("failed to run test: %v", err)
+	}
+	if got := output.stdout.String(); got != "{\"ok\":true}\n" {
+		t.Errorf("unexpected stdout %q", got)
+	}
+	if got := output.stderr.String(); got != "noise\n" {
+		t.Errorf("unexpected stderr %q", got)
+	}
+	if got := output.String(); !strings.Contains(got, "noise\n") || !strings.Contains(got, "{\"ok\":true}\n") {
+		t.Errorf("expected combined output, got %q", got)
+	}
+}
+
+func TestFailureOutput(t *testing.T) {
+	for _, tt := range []struct {
+		s       string
+		n       int
+		tail    string
+		dropped int
+	}{
+		{s: "a\nb\nc\n", n: 2, tail: "b\nc\n", dropped: 1},
+		{s: "a\nb\nc", n: 1, tail: "c", dropped: 2},
+		{s: "a\nb\nc\n", n: 3, tail: "a\nb\nc\n"},
+		{s: "a\nb\nc\n", n: 0, tail: "a\nb\nc\n"},
+	} {
+		if tail, dropped := tailLines(tt.s, tt.n); tail != tt.tail || dropped != tt.dropped {
+			t.Errorf("tailLines(%q, %d): expected %q and %d dropped, got %q and %d", tt.s, tt.n, tt.tail, tt.dropped, tail, dropped)
+		}
+	}
+
+	output := strings.Repeat("log line\n", 60) + "--- FAIL: TestA\n"
+	r := &Runner{}
+	if got := r.failureOutput(output); !strings.HasPrefix(got, "... 11 earlier lines of output not shown; run with -v to see it ...\n") || !strings.HasSuffix(got, "--- FAIL: TestA\n") {
+		t.Errorf("expected the last 50 lines by default, got %q", got)
+	}
+	all := 0
+	r.config.FailureOutputLines = &all
+	if got := r.failureOutput(output); got != output {
+		t.Errorf("expected all output with failure-output-lines 0")
+	}
+}
+
+func TestSuiteCommands(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRunner(RunnerConfig{
+		Dockerfile:      "Dockerfile",
+		TestDir:         dir,
+		DockerRunArgs:   []string{"--network=e2e-net"},
+		SetupCommand:    "echo setup > setup.log",
+		TeardownCommand: "echo teardown > teardown.log",
+	})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if network := r.testNetwork(); network != "e2e-net" {
+		t.Errorf("expected test network e2e-net, got %q", network)
+	}
+	if err := r.runSuiteCommand("setup", r.config.SetupCommand); err != nil {
+		t.Fatalf("setup command failed: %v", err)
+	}
+	r.setupCommandRan = true
+	r.Cleanup()
+	for _, file := range []string{"setup.log", "teardown.log"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Errorf("expected %s to be written: %v", file, err)
+		}
+	}
+
+	if err := r.runSuiteCommand("setup", "echo seeding; exit 3"); err == nil || !strings.Contains(err.Error(), "seeding") {
+		t.Errorf("expected setup failure with its output, got %v", err)
+	}
+}
+
+func TestStartHeartbeat(t *testing.T) {
+	var output bytes.Buffer
+	stop := startHeartbeat(&output, "building", time.Now(), 10*time.Millisecond)
+	time.Sleep(35 * time.Millisecond)
+	stop()
+	if !strings.Contains(output.String(), "--- INFO: Still building... 0s elapsed") {
+		t.Errorf("expected heartbeat output, got %q", output.String())
+	}
+	n := output.Len()
+	time.Sleep(20 * time.Millisecond)
+	if output.Len() != n {
+		t.Errorf("expected no output after stop")
+	}
+
+	startHeartbeat(&output, "building", time.Now(), 0)()
+}
+
+func TestExcludeDirs(t *testing.T) {
+	if err := validateExcludeDirs([]string{"[vendor"}); err == nil {
+		t.Errorf("expected error for invalid exclude dir pattern")
+	}
+
+	dir := t.TempDir()
+	for _, sub := range []string{"vendor/dep", "internal/mocks", "gen/x"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", sub, err)
+		}
+	}
+	writeTestFile(t, dir, "a_test.go", "package a\n\nfunc TestA(t *testing.T) {}\n")
+	writeTestFile(t, filepath.Join(dir, "vendor", "dep"), "dep_test.go", "package dep\n\nfunc TestVendored(t *testing.T) {}\n")
+	writeTestFile(t, filepath.Join(dir, "internal", "mocks"), "mock_test.go", "package mocks\n\nfunc TestMock(t *testing.T) {}\n")
+	writeTestFile(t, filepath.Join(dir, "gen", "x"), "x_test.go", "package x\n\nfunc TestGenerated(t *testing.T) {}\n")
+
+	r := &Runner{config: RunnerConfig{TestDir: dir, ExcludeDirs: []string{"vendor", "*mocks", "gen/"}}}
+	tests, err := r.getTestsToRun()
+	if err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	if fmt.Sprint(tests) != "[TestA]" {
+		t.Errorf("expected only TestA, got %v", tests)
+	}
+}
+
+func TestReportSubtests(t *testing.T) {
+	r := &Runner{
+		passedTests:  []string{"TestA"},
+		skippedTests: []string{"TestB"},
+		testTimings:  map[string]time.Duration{"TestA": time.Second},
+	}
+	if !t.Run("report", r.ReportSubtests) {
+		t.Errorf("expected passed and skipped tests to report passing subtests")
+	}
+}
+
+func TestTestWeights(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\n//go:e2e weight: 3\nfunc TestHeavy(t *testing.T) {}\n\nfunc TestLight(t *testing.T) {}\n")
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TestDir: dir})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if _, err := r.getTestsToRun(); err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	if r.testWeight("TestHeavy") != 3 || r.testWeight("TestLight") != 1 {
+		t.Errorf("expected weights 3 and 1, got %d and %d", r.testWeight("TestHeavy"), r.testWeight("TestLight"))
+	}
+	for _, annotation := range []string{"//go:e2e weight: 0", "//go:e2e weight: heavy"} {
+		writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\n"+annotation+"\nfunc TestA(t *testing.T) {}\n")
+		if _, err := r.getTestsToRun(); err == nil {
+			t.Errorf("expected error for annotation %q", annotation)
+		}
+	}
+
+	// A weight over the size takes all of it.
+	sem := newWeightedSemaphore(4)
+	sem.acquire(3)
+	acquired := make(chan struct{})
+	go func() {
+		sem.acquire(10)
+		close(acquired)
+	}()
+	sem.acquire(1)
+	select {
+	case <-acquired:
+		t.Fatalf("expected heavy acquire to wait for capacity")
+	case <-time.After(10 * time.Millisecond):
+	}
+	sem.release(3)
+	sem.release(1)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("expected heavy acquire once capacity is free")
+	}
+}
+
+func TestCheckRemoteMounts(t *testing.T) {
+	for host, remote := range map[string]bool{
+		"":                            false,
+		"unix:///var/run/docker.sock": false,
+		"tcp://127.0.0.1:2375":        false,
+		"tcp://localhost:2375":        false,
+		"tcp://10.0.0.5:2376":         true,
+		"ssh://ci@builder":            true,
+	} {
+		t.Setenv("DOCKER_HOST", host)
+		if got := remoteDockerHost() != ""; got != remote {
+			t.Errorf("expected remote %v for DOCKER_HOST %q, got %v", remote, host, got)
+		}
+	}
+
+	t.Setenv("DOCKER_HOST", "ssh://ci@builder")
+	r := &Runner{}
+	if err := r.checkRemoteMounts(); err != nil {
+		t.Errorf("unexpected error without bind mounts: %v", err)
+	}
+	r.config.DataVolumes = []string{"/data:/data:ro"}
+	r.testFixtures = map[string]string{"TestA": "/fixtures/TestA"}
+	err := r.checkRemoteMounts()
+	if err == nil || !strings.Contains(err.Error(), "data-volumes entry /data:/data:ro, fixtures of TestA") {
+		t.Errorf("expected error listing the bind mounts, got %v", err)
+	}
+}
+
+func TestRequiresAnnotation(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\n//go:e2e requires: "+listener.Addr().String()+"\nfunc TestA(t *testing.T) {}\n\n//go:e2e requires: postgres, redis\nfunc TestB(t *testing.T) {}\n")
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TestDir: dir, TestPattern: "TestA"})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if r.testsToRun, err = r.getTestsToRun(); err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	r.config.TestPattern = ""
+	if _, err := r.getTestsToRun(); err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	if got := fmt.Sprint(r.testRequires["TestB"]); got != "[container:postgres container:redis]" {
+		t.Errorf("expected container dependencies for TestB, got %s", got)
+	}
+
+	// Only the dependencies of the tests to run are waited for.
+	if err := r.waitForDependencies(); err != nil {
+		t.Errorf("unexpected error waiting for TestA's dependencies: %v", err)
+	}
+	if err := r.waitForTestDependencies("TestA"); err != nil {
+		t.Errorf("unexpected error waiting for TestA's dependencies: %v", err)
+	}
+
+	writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\n//go:e2e requires: db:5432:1\nfunc TestA(t *testing.T) {}\n")
+	if _, err := r.getTestsToRun(); err == nil {
+		t.Errorf("expected error for invalid requires annotation")
+	}
+}
+
+func TestSkipUnlessEnvAnnotation(t *testing.T) {
+	t.Setenv("E2E_TEST_SET", "1")
+	t.Setenv("E2E_TEST_UNSET", "")
+
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\n//go:e2e skip-unless-env: E2E_TEST_SET\nfunc TestA(t *testing.T) {}\n\n//go:e2e skip-unless-env: E2E_TEST_SET, E2E_TEST_UNSET\nfunc TestB(t *testing.T) {}\n")
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TestDir: dir})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	tests, err := r.getTestsToRun()
+	if err != nil {
+		t.Fatalf("failed to get tests: %v", err)
+	}
+	if !slices.Equal(tests, []string{"TestA"}) {
+		t.Errorf("expected only TestA to run, got %v", tests)
+	}
+	if !slices.Equal(r.envSkippedTests, []string{"TestB"}) {
+		t.Errorf("expected TestB to be skipped, got %v", r.envSkippedTests)
+	}
+	if results := r.Results(); results.Skipped != 1 || results.Failed != 0 {
+		t.Errorf("expected 1 skipped and 0 failed tests, got %+v", results)
+	}
+
+	writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\n//go:e2e skip-unless-env: A=1\nfunc TestA(t *testing.T) {}\n")
+	if _, err := r.getTestsToRun(); err == nil {
+		t.Errorf("expected error for invalid skip-unless-env annotation")
+	}
+}
+
+func TestGroupFailures(t *testing.T) {
+	r := &Runner{
+		failedTests: []string{"TestA", "TestB", "TestC"},
+		testStreams: map[string]testStreams{
+			"TestA": {output: "--- FAIL: TestA (0.12s)\n    db.go:10: connection refused at 2024-01-02T03:04:05Z\n"},
+			"TestB": {output: "--- FAIL: TestB (1.5s)\n    db.go:10: connection refused at 2024-01-02T03:04:09Z\n"},
+			"TestC": {output: "--- FAIL: TestC (0.01s)\n    c_test.go:5: expected 1, got 2\n"},
+		},
+	}
+	groups := r.groupFailures()
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 failure groups, got %d: %+v", len(groups), groups)
+	}
+	if fmt.Sprint(groups[0].tests) != "[TestA TestB]" || fmt.Sprint(groups[1].tests) != "[TestC]" {
+		t.Errorf("unexpected failure groups %+v", groups)
+	}
+
+	r.cancelledTests = []string{"TestB"}
+	if groups := r.groupFailures(); fmt.Sprint(groups[0].tests) != "[TestA]" {
+		t.Errorf("expected cancelled tests to be left out, got %+v", groups)
+	}
+}
+
+func TestRestartPolicy(t *testing.T) {
+	for policy, valid := range map[string]bool{
+		"":               true,
+		"no":             true,
+		"on-failure":     true,
+		"on-failure:3":   true,
+		"on-failure:0":   false,
+		"no:3":           false,
+		"always":         false,
+		"unless-stopped": false,
+		"sometimes":      false,
+	} {
+		if err := validateRestartPolicy(policy); (err == nil) != valid {
+			t.Errorf("expected valid %v for restart policy %q, got %v", valid, policy, err)
+		}
+	}
+
+	r := &Runner{config: RunnerConfig{RestartPolicy: "on-failure:3"}}
+	args := strings.Join(r.dockerRunArgs("TestA", "e2e-TestA"), " ")
+	if !strings.Contains(args, "--restart on-failure:3") || strings.Contains(args, "--rm") {
+		t.Errorf("expected --restart without --rm, got %q", args)
+	}
+
+	bin := t.TempDir()
+	writeTestFile(t, bin, "docker", `#!/bin/sh
+case "$1" in
+inspect) echo exited 0 2 2026-10-15T08:00:00Z ;;
+logs) echo "$@" ;;
+esac
+`)
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	firstErr := exec.Command("sh", "-c", "exit 1").Run()
+	var output bytes.Buffer
+	if err := r.waitForRestarts(t.Context(), "e2e-TestA", firstErr, &output); err != nil {
+		t.Errorf("expected the last successful run to pass, got %v", err)
+	}
+	if !strings.Contains(output.String(), "restarted 2 times") {
+		t.Errorf("expected restarts to be reported, got %q", output.String())
+	}
+	if !strings.Contains(output.String(), "--- INFO: Output of the last run:\nlogs --since 2026-10-15T08:00:00Z e2e-TestA\n") {
+		t.Errorf("expected the output of the last run, got %q", output.String())
+	}
+}
+
+func TestPruneKeepsRunningContainers(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	bin := t.TempDir()
+	// Running containers are only listed by docker ps without --all, and
+	// stopped ones only with the status filters.
+	writeTestFile(t, bin, "docker", `#!/bin/sh
+echo "$@" >> `+bin+`/calls
+case "$*" in
+images*) printf 'e2e-test-runner-old:dev\ne2e-test-runner-live:dev\n' ;;
+*--all*status=created*status=exited*) echo e2e-TestA-old ;;
+"ps --filter name=^e2e- --format {{.Image}}") echo e2e-test-runner-live:dev ;;
+esac
+`)
+	if err := os.Chmod(filepath.Join(bin, "docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	if err := Prune(false, 0); err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	calls, err := os.ReadFile(filepath.Join(bin, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(calls), "rm --force e2e-TestA-old\n") {
+		t.Errorf("expected the stopped container to be removed, got %q", calls)
+	}
+	if !strings.Contains(string(calls), "rmi --force e2e-test-runner-old:dev\n") {
+		t.Errorf("expected only the image without running containers to be removed, got %q", calls)
+	}
+}
+
+func TestDiscoverTestsWithoutDocker(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a_test.go", "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n\nfunc TestSkipped(t *testing.T) {}\n")
+	writeTestFile(t, dir, "slow_test.go", "//go:build slow\n\npackage a\n\nimport \"testing\"\n\nfunc TestSlow(t *testing.T) {}\n")
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", TestDir: dir, BuildTags: []string{"e2e"}, SkipPattern: "Skipped"})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	tests, err := r.DiscoverTests()
+	if err != nil {
+		t.Fatalf("failed to discover tests: %v", err)
+	}
+	var got []string
+	for _, test := range tests {
+		got = append(got, fmt.Sprintf("%s %s %q %q", test.Name, filepath.Base(test.File), test.BuildConstraint, test.Excluded))
+	}
+	want := []string{
+		`TestA a_test.go "" ""`,
+		`TestSkipped a_test.go "" "matches skip pattern \"Skipped\""`,
+		`TestSlow slow_test.go "slow" "build constraint \"slow\" not satisfied"`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRunPatternTemplate(t *testing.T) {
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", RunPatternTemplate: "^{{.Nme}}$"}); err == nil {
+		t.Errorf("expected error for a template with an unknown field")
+	}
+
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile"})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if got := r.runPattern("TestA"); got != "^TestA$" {
+		t.Errorf("expected default pattern ^TestA$, got %q", got)
+	}
+
+	r, err = NewRunner(RunnerConfig{Dockerfile: "Dockerfile", RunPatternTemplate: "^{{.Name}}$/^Smoke"})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if args := strings.Join(r.dockerRunArgs("TestA", "e2e-TestA"), " "); !strings.Contains(args, "-test.run ^TestA$/^Smoke") {
+		t.Errorf("expected templated run pattern, got %q", args)
+	}
+}
+
+func TestMaxTestDuration(t *testing.T) {
+	r := &Runner{config: RunnerConfig{MaxTestDuration: time.Second}}
+	var output bytes.Buffer
+	if err := r.checkTestDuration("TestFast", 500*time.Millisecond, &output); err != nil {
+		t.Errorf("unexpected error for a test within the limit: %v", err)
+	}
+	err := r.checkTestDuration("TestSlow", 1500*time.Millisecond, &output)
+	if err == nil || err.Error() != "too slow: took 1.50s (limit 1s)" {
+		t.Errorf("expected too slow error, got %v", err)
+	}
+	if !strings.Contains(output.String(), "--- ERROR: Test too slow") || fmt.Sprint(r.tooSlowTests) != "[TestSlow]" {
+		t.Errorf("expected TestSlow to be reported as too slow, got %q and %v", output.String(), r.tooSlowTests)
+	}
+
+	r.config.MaxTestDuration = 0
+	if err := r.checkTestDuration("TestSlow", time.Hour, &output); err != nil {
+		t.Errorf("unexpected error without a limit: %v", err)
+	}
+}
+
+func TestRunID(t *testing.T) {
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", RunID: "ci/123"}); err == nil {
+		t.Errorf("expected error for invalid run id")
+	}
+	r, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", RunID: "ci-123"})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	r.runID = r.config.RunID
+	args := strings.Join(r.dockerRunArgs("TestA", "e2e-TestA"), " ")
+	if r.RunID() != "ci-123" || !strings.Contains(args, "-e E2E_RUN_ID=ci-123 ") || !strings.Contains(args, "--label e2e.run=ci-123 ") {
+		t.Errorf("expected run id ci-123 in env and labels, got %q", args)
+	}
+}
+
+func TestDockerfileCommand(t *testing.T) {
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", DockerfileCommand: "cat Dockerfile.tmpl"}); err == nil {
+		t.Errorf("expected error for more than one Dockerfile source")
+	}
+
+	dir := t.TempDir()
+	writeTestFile(t, dir, "Dockerfile.tmpl", "FROM golang:{{GO_VERSION}}\n")
+	r, err := NewRunner(RunnerConfig{TestDir: dir, DockerfileCommand: "sed s/{{GO_VERSION}}/1.24/ Dockerfile.tmpl"})
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	content, err := r.generateDockerfile()
+	if err != nil {
+		t.Fatalf("failed to generate Dockerfile: %v", err)
+	}
+	if content != "FROM golang:1.24\n" {
+		t.Errorf("unexpected Dockerfile %q", content)
+	}
+
+	r.config.DockerfileCommand = "echo oops >&2; exit 1"
+	if _, err := r.generateDockerfile(); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("expected error with the command's stderr, got %v", err)
+	}
+	r.config.DockerfileCommand = "true"
+	if _, err := r.generateDockerfile(); err == nil {
+		t.Errorf("expected error for empty output")
+	}
+}
+
+func TestBuildDuration(t *testing.T) {
+	r := &Runner{buildDuration: 2 * time.Second, suiteDuration: time.Second}
+	if results := r.Results(); results.BuildDuration != 2*time.Second || results.Duration != time.Second {
+		t.Errorf("expected build and test durations in results, got %s and %s", results.BuildDuration, results.Duration)
+	}
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	var got webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s with content type %q", req.Method, req.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(req.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode payload: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	r := &Runner{
+		config:        RunnerConfig{WebhookURL: server.URL},
+		runID:         "ci-123",
+		passedTests:   []string{"TestA"},
+		failedTests:   []string{"TestB"},
+		skippedTests:  []string{"TestC"},
+		suiteDuration: 1500 * time.Millisecond,
+	}
+	if err := r.postWebhook("FAIL"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := webhookPayload{RunID: "ci-123", Status: "FAIL", Passed: 1, Failed: 1, Skipped: 1, Duration: 1.5, FailedTests: []string{"TestB"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected payload %+v, got %+v", want, got)
+	}
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if err := r.postWebhook("FAIL"); err == nil {
+		t.Errorf("expected error for failing webhook")
+	}
+
+	if _, err := NewRunner(RunnerConfig{Dockerfile: "Dockerfile", WebhookURL: "hooks.example.com"}); err == nil {
+		t.Errorf("expected error for webhook url without scheme")
+	}
+}
+
+func TestResultLine(t *testing.T) {
+	r := &Runner{}
+	if got := r.resultLine("PASS", "TestA", 1234*time.Millisecond); got != "--- PASS: TestA (1.23s)\n" {
+		t.Errorf("unexpected result line %q", got)
+	}
+	r.config.Compact = true
+	if got := r.resultLine("FAIL", "TestA", 1234*time.Millisecond); got != "FAIL TestA 1.23s\n" {
+		t.Errorf("unexpected compact result line %q", got)
+	}
+	r.color = true
+	if got := r.resultLine("PASS", "TestA", 1234*time.Millisecond); got != colorGreen+"PASS"+colorReset+" TestA 1.23s\n" {
+		t.Errorf("unexpected colorized compact result line %q", got)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	r := &Runner{
+		config:           RunnerConfig{StrictMode: true},
+		testsToRun:       []string{"TestA", "TestB", "TestC", "TestD", "TestE"},
+		passedTests:      []string{"TestA"},
+		failedTests:      []string{"TestB"},
+		incompleteTests:  []string{"TestC"},
+		selfSkippedTests: []string{"TestD"},
+		envSkippedTests:  []string{"TestF"},
+	}
+	tests, reasons := r.notPassedTests()
+	if !slices.Equal(tests, []string{"TestB", "TestC", "TestD", "TestE", "TestF"}) {
+		t.Fatalf("expected all tests but TestA not to have passed, got %v", tests)
+	}
+	want := map[string]string{"TestB": "FAIL", "TestC": "STOP", "TestD": "SKIP", "TestE": "NOT RUN", "TestF": "SKIP"}
+	for test, reason := range want {
+		if reasons[test] != reason {
+			t.Errorf("expected %s for %s, got %q", reason, test, reasons[test])
+		}
+	}
+	if !r.strictModeFailed() {
+		t.Errorf("expected strict mode to fail the run")
+	}
+	if err := (&StrictModeError{NotPassed: tests}).Error(); !strings.Contains(err, "5 tests did not pass: TestB, TestC") {
+		t.Errorf("unexpected error %q", err)
+	}
+
+	r = &Runner{config: RunnerConfig{StrictMode: true}, testsToRun: []string{"TestA"}, passedTests: []string{"TestA"}}
+	if r.strictModeFailed() {
+		t.Errorf("expected strict mode to pass when every test passed")
+	}
+	r.config.StrictMode = false
+	r.passedTests = nil
+	if r.strictModeFailed() {
+		t.Errorf("expected no strict mode failure without StrictMode")
+	}
+}
@@ -1,8 +1,10 @@
 package e2e_test
 
 import (
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	e2e "github.com/snormore/go-e2e/lib"
 )
@@ -48,3 +50,63 @@ func TestSuiteRunner_SimpleFailing(t *testing.T) {
 		t.Fatalf("expected test to fail with error containing 'tests failed' but got: %v", err)
 	}
 }
+
+func TestSuiteRunner_DockerfileNotFound(t *testing.T) {
+	runner, err := e2e.NewRunner(e2e.RunnerConfig{
+		TestDir:    "../examples/simple-passing",
+		Dockerfile: "Missing.Dockerfile",
+	})
+	if err != nil {
+		t.Fatalf("failed to create test runner: %v", err)
+	}
+
+	if err := runner.Setup(); !errors.Is(err, e2e.ErrDockerfileNotFound) {
+		t.Fatalf("expected ErrDockerfileNotFound but got: %v", err)
+	}
+}
+
+func TestSuiteRunner_DaemonUnavailable(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	runner, err := e2e.NewRunner(e2e.RunnerConfig{
+		TestDir:    "../examples/simple-passing",
+		Dockerfile: "Dockerfile",
+	})
+	if err != nil {
+		t.Fatalf("failed to create test runner: %v", err)
+	}
+
+	if err := runner.Setup(); !errors.Is(err, e2e.ErrDaemonUnavailable) {
+		t.Fatalf("expected ErrDaemonUnavailable but got: %v", err)
+	}
+}
+
+func TestSuiteRunner_RunWithRetry(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	runner, err := e2e.NewRunner(e2e.RunnerConfig{
+		TestDir:    "../examples/simple-passing",
+		Dockerfile: "Dockerfile",
+	})
+	if err != nil {
+		t.Fatalf("failed to create test runner: %v", err)
+	}
+	if err := runner.RunWithRetry(2, time.Millisecond); !errors.Is(err, e2e.ErrDaemonUnavailable) {
+		t.Fatalf("expected ErrDaemonUnavailable but got: %v", err)
+	}
+
+	runner, err = e2e.NewRunner(e2e.RunnerConfig{
+		TestDir:    "../examples/simple-passing",
+		Dockerfile: "Dockerfile.missing",
+	})
+	if err != nil {
+		t.Fatalf("failed to create test runner: %v", err)
+	}
+	start := time.Now()
+	if err := runner.RunWithRetry(2, 10*time.Second); !errors.Is(err, e2e.ErrDockerfileNotFound) {
+		t.Fatalf("expected ErrDockerfileNotFound but got: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("expected ErrDockerfileNotFound not to be retried")
+	}
+}
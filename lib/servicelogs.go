@@ -0,0 +1,43 @@
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// serviceContainers returns the containers named by container:<name> entries
+// in WaitFor.
+func (r *Runner) serviceContainers() []string {
+	var containers []string
+	for _, dep := range r.config.WaitFor {
+		if name, ok := strings.CutPrefix(dep, waitForContainer); ok {
+			containers = append(containers, name)
+		}
+	}
+	return containers
+}
+
+// serviceLogs returns the logs of the service containers between start and
+// end, each under a header naming the container.
+func (r *Runner) serviceLogs(start, end time.Time) string {
+	var buf bytes.Buffer
+	for _, name := range r.serviceContainers() {
+		cmd := exec.Command("docker", "logs",
+			"--since", start.Format(time.RFC3339Nano),
+			"--until", end.Format(time.RFC3339Nano),
+			name)
+		if r.config.Verbosity > 1 {
+			fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+		}
+		output, err := cmd.CombinedOutput()
+		fmt.Fprintf(&buf, "=== LOGS: %s\n", name)
+		if err != nil {
+			fmt.Fprintf(&buf, "failed to collect logs: %v\n", err)
+		}
+		buf.Write(output)
+	}
+	return buf.String()
+}
@@ -0,0 +1,101 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// startSharedContainer starts the long-lived container that all tests are
+// run in with SharedContainer, kept alive with sleep instead of the test
+// binary, and removed by Cleanup.
+func (r *Runner) startSharedContainer() error {
+	binary, err := r.testBinaryPath()
+	if err != nil {
+		return err
+	}
+
+	// The run ID can be shared by suites run concurrently with -run-id, so it
+	// is only a label and the name is unique to this runner.
+	name := "e2e-shared-" + randomShortID()
+	cmd := exec.Command("docker", r.sharedContainerArgs(name)...)
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to start shared container: %v\n%s", err, output)
+	}
+	fmt.Printf("--- INFO: Running tests in shared container %s\n", name)
+
+	r.sharedContainer = name
+	r.execContainer = name
+	r.execBinary = binary
+	return nil
+}
+
+// restartSharedContainer restarts the shared container without waiting for
+// its processes to exit, to kill a test whose docker exec was killed.
+func (r *Runner) restartSharedContainer() error {
+	fmt.Printf("--- INFO: Restarting shared container %s to stop the killed test\n", r.sharedContainer)
+	cmd := exec.Command("docker", "restart", "--time", "0", r.sharedContainer)
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to restart shared container %s: %v\n%s", r.sharedContainer, err, output)
+	}
+	return nil
+}
+
+// sharedContainerArgs returns the docker run arguments of the shared
+// container with the given name.
+func (r *Runner) sharedContainerArgs(name string) []string {
+	args := []string{"run", "--detach", "--rm", "--name", name}
+	if r.config.RunPlatform != "" {
+		args = append(args, "--platform", r.config.RunPlatform)
+	}
+	for _, vol := range r.config.DataVolumes {
+		args = append(args, "-v", vol)
+	}
+	for _, host := range r.config.AddHosts {
+		args = append(args, "--add-host", host)
+	}
+	args = append(args, r.dnsArgs()...)
+	// Tests are exec'd in AssetsMountPath, which must exist in the container.
+	args = append(args, r.assetsArgs()...)
+	if r.binaryPath != "" {
+		args = append(args, "-v", r.binaryPath+":"+mountedBinaryPath+":ro")
+	}
+	if r.config.User != "" {
+		args = append(args, "--user", r.config.User)
+	}
+	labels := r.containerLabels("")
+	delete(labels, "e2e.test")
+	args = append(args, labelArgs(labels)...)
+	args = append(args, r.runArgs...)
+	return append(args, "--entrypoint", "sleep", r.containerBuildImage, "infinity")
+}
+
+// testBinaryPath returns the path of the test binary in the test image: the
+// mounted binary with MountBinary, the Entrypoint if set, or else the
+// image's entrypoint.
+func (r *Runner) testBinaryPath() (string, error) {
+	if r.binaryPath != "" {
+		return mountedBinaryPath, nil
+	}
+	if r.config.Entrypoint != "" {
+		return r.config.Entrypoint, nil
+	}
+
+	cmd := exec.Command("docker", "inspect", "--format", "{{json .Config.Entrypoint}}", r.containerBuildImage)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %v\n%s", r.containerBuildImage, err, output)
+	}
+	var entrypoint []string
+	if err := json.Unmarshal(output, &entrypoint); err != nil || len(entrypoint) == 0 {
+		return "", fmt.Errorf("image %s has no entrypoint; set entrypoint to the test binary path", r.containerBuildImage)
+	}
+	return entrypoint[0], nil
+}
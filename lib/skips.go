@@ -0,0 +1,10 @@
+package e2e
+
+import "regexp"
+
+// testSkipped reports whether go test -v output shows that the named
+// top-level test called t.Skip.
+func testSkipped(output, test string) bool {
+	re := regexp.MustCompile(`(?m)^--- SKIP: ` + regexp.QuoteMeta(test) + ` \(`)
+	return re.MatchString(output)
+}
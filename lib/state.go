@@ -0,0 +1,154 @@
+package e2e
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runState is persisted after each run so that later runs can act on its
+// results.
+type runState struct {
+	// Failed lists the tests that failed or did not complete.
+	Failed []string `json:"failed"`
+
+	// Tests records the last result of each test by its package directory
+	// and name, along with the hash of the sources it depended on at the
+	// time; see SinceLastPass.
+	Tests map[string]testState `json:"tests,omitempty"`
+}
+
+// testState is the last recorded result of a test.
+type testState struct {
+	Hash   string `json:"hash"`
+	Passed bool   `json:"passed"`
+}
+
+// cacheDir returns the directory used for state and cache files.
+func cacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user cache directory: %v", err)
+	}
+	return filepath.Join(dir, "go-e2e"), nil
+}
+
+// stateFilePath returns the state file path for the given test directory.
+func stateFilePath(testDir string) (string, error) {
+	return cacheFilePath("state", testDir)
+}
+
+// cacheFilePath returns the path of the cache file of the given kind for the
+// given test directory.
+func cacheFilePath(kind, testDir string) (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	absDir, err := filepath.Abs(testDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	sum := sha256.Sum256([]byte(absDir))
+	return filepath.Join(dir, kind+"-"+hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// saveState writes the results of the last run to the state file.
+func (r *Runner) saveState() error {
+	path, err := stateFilePath(r.config.TestDir)
+	if err != nil {
+		return err
+	}
+
+	// Keep the results of tests that were not run this time.
+	tests := make(map[string]testState)
+	if prev, err := loadState(r.config.TestDir); err == nil {
+		for name, ts := range prev.Tests {
+			tests[name] = ts
+		}
+	}
+	// Without hashes, the tests are recorded as changed, so that
+	// SinceLastPass runs them again.
+	hashes, err := r.testSourceHashes()
+	if err != nil {
+		fmt.Printf("--- INFO: Failed to hash test sources: %v\n", err)
+	}
+
+	r.mu.Lock()
+	state := runState{Failed: append(append([]string{}, r.failedTests...), r.incompleteTests...)}
+	for _, id := range r.passedTests {
+		for _, key := range r.testStateKeys(r.testName(id)) {
+			tests[key] = testState{Hash: hashes[key], Passed: true}
+		}
+	}
+	// A failure in any matrix cell marks the test as failed.
+	for _, id := range state.Failed {
+		for _, key := range r.testStateKeys(r.testName(id)) {
+			tests[key] = testState{Hash: hashes[key]}
+		}
+	}
+	r.mu.Unlock()
+	state.Tests = tests
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	return nil
+}
+
+// loadState reads the results of the last run from the state file.
+func loadState(testDir string) (*runState, error) {
+	path, err := stateFilePath(testDir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no previous run found for %s", testDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %v", err)
+	}
+	var state runState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state %s: %v", path, err)
+	}
+	return &state, nil
+}
+
+// filterFailedTests restricts the tests to run to those that failed or did
+// not complete in the last run.
+func (r *Runner) filterFailedTests() error {
+	state, err := loadState(r.config.TestDir)
+	if err != nil {
+		return err
+	}
+	failed := make(map[string]bool, len(state.Failed))
+	for _, t := range state.Failed {
+		// Matrix runs are saved as "TestName [cell]"; a failure in any cell
+		// re-runs the test in all cells.
+		name, _, _ := strings.Cut(t, " [")
+		failed[name] = true
+	}
+	var tests []string
+	for _, t := range r.testsToRun {
+		if failed[t] {
+			tests = append(tests, t)
+		}
+	}
+	r.testsToRun = tests
+	fmt.Printf("--- INFO: Re-running %d previously failed tests\n", len(tests))
+	return nil
+}
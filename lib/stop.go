@@ -0,0 +1,65 @@
+package e2e
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStopSignal  = "SIGTERM"
+	defaultStopTimeout = 10 * time.Second
+)
+
+// gracefulStop reports whether cancelled test containers should be stopped
+// with docker stop rather than killed.
+func (r *Runner) gracefulStop() bool {
+	return r.config.StopSignal != "" || r.config.StopTimeout > 0
+}
+
+// stopTimeout returns the time a cancelled container is given to exit after
+// the stop signal before it is killed.
+func (r *Runner) stopTimeout() time.Duration {
+	if r.config.StopTimeout > 0 {
+		return r.config.StopTimeout
+	}
+	return defaultStopTimeout
+}
+
+// stopContainer sends the stop signal to a container, waits for it to exit
+// for up to the stop timeout, and then force-removes it.
+func (r *Runner) stopContainer(name string) error {
+	signal := r.config.StopSignal
+	if signal == "" {
+		signal = defaultStopSignal
+	}
+	seconds := int(r.stopTimeout().Round(time.Second) / time.Second)
+
+	stopCmd := exec.Command("docker", "stop", "--signal", signal, "--time", strconv.Itoa(seconds), name)
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(stopCmd.Args, " "))
+	}
+	stopOutput, stopErr := stopCmd.CombinedOutput()
+
+	// The container is usually already removed by --rm once stopped.
+	_ = r.removeContainer(name)
+
+	if stopErr != nil {
+		return fmt.Errorf("failed to stop container %s: %v\n%s", name, stopErr, stopOutput)
+	}
+	return nil
+}
+
+// removeContainer kills and removes a container.
+func (r *Runner) removeContainer(name string) error {
+	cmd := exec.Command("docker", "rm", "--force", name)
+	if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Running: %s\n", strings.Join(cmd.Args, " "))
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove container %s: %v\n%s", name, err, output)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package e2e
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StrictModeError is returned by RunTests with StrictMode when not every
+// test selected to run passed. Unlike a TestsFailedError, it is not subject
+// to the ExitCodePolicy.
+type StrictModeError struct {
+	// NotPassed are the tests that did not pass, in the order they were
+	// selected to run.
+	NotPassed []string
+}
+
+func (e *StrictModeError) Error() string {
+	return fmt.Sprintf("strict mode: %d tests did not pass: %s", len(e.NotPassed), strings.Join(e.NotPassed, ", "))
+}
+
+// notPassedTests returns the tests selected to run that did not pass, and
+// the tests skipped by a skip-unless-env annotation, along with why.
+func (r *Runner) notPassedTests() ([]string, map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reasons := make(map[string]string)
+	for _, t := range r.passedTests {
+		reasons[t] = ""
+	}
+	mark := func(tests []string, reason string) {
+		for _, t := range tests {
+			reasons[t] = reason
+		}
+	}
+	mark(r.failedTests, "FAIL")
+	mark(r.incompleteTests, "STOP")
+	mark(r.selfSkippedTests, "SKIP")
+	mark(r.budgetSkippedTests, "SKIP")
+
+	var tests []string
+	for _, t := range r.testsToRun {
+		reason, ok := reasons[t]
+		if !ok {
+			reason = "NOT RUN"
+		}
+		if reason != "" {
+			tests = append(tests, t)
+			reasons[t] = reason
+		}
+	}
+	for _, t := range r.envSkippedTests {
+		tests = append(tests, t)
+		reasons[t] = "SKIP"
+	}
+	return tests, reasons
+}
+
+// strictModeFailed reports whether the run fails because of StrictMode.
+func (r *Runner) strictModeFailed() bool {
+	if !r.config.StrictMode {
+		return false
+	}
+	tests, _ := r.notPassedTests()
+	return len(tests) > 0
+}
+
+// printNotPassedTests prints the tests that did not pass, with StrictMode.
+func (r *Runner) printNotPassedTests() {
+	if !r.config.StrictMode {
+		return
+	}
+	tests, reasons := r.notPassedTests()
+	if len(tests) == 0 {
+		return
+	}
+	fmt.Printf("\n=== NOT PASSED (strict mode)\n")
+	for _, t := range tests {
+		fmt.Printf("%s: %s\n", r.status(reasons[t]), t)
+	}
+}
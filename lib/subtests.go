@@ -0,0 +1,39 @@
+package e2e
+
+import "testing"
+
+// RunAsSubtests sets up the runner, runs the tests and reports each of them
+// as a subtest of t with ReportSubtests, so that a go test wrapping go-e2e
+// shows a result per test in IDEs and CI. It cleans up after.
+func (r *Runner) RunAsSubtests(t *testing.T) {
+	t.Helper()
+	defer r.Cleanup()
+	if err := r.Setup(); err != nil {
+		t.Fatalf("failed to set up test runner: %v", err)
+	}
+	err := r.RunTests()
+	r.ReportSubtests(t)
+	if err != nil && r.Results().Failed == 0 {
+		t.Errorf("failed to run tests: %v", err)
+	}
+}
+
+// ReportSubtests reports the results of the last RunTests call as subtests
+// of t. Failed tests fail their subtest with their output, and skipped
+// tests and tests that did not complete are skipped. The tests have already
+// run, so the subtests' durations are not the tests'.
+func (r *Runner) ReportSubtests(t *testing.T) {
+	t.Helper()
+	for _, result := range r.Results().Tests {
+		t.Run(result.Name, func(t *testing.T) {
+			switch result.Status {
+			case "FAIL":
+				t.Errorf("failed after %.2fs:\n%s", result.Duration.Seconds(), result.Output)
+			case "SKIP":
+				t.Skip("skipped")
+			case "STOP":
+				t.Skip("did not complete")
+			}
+		})
+	}
+}
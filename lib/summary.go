@@ -0,0 +1,200 @@
+package e2e
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+const defaultSlowestN = 10
+
+type testTiming struct {
+	name     string
+	duration time.Duration
+}
+
+// slowestTests returns up to n tests sorted by descending duration.
+func (r *Runner) slowestTests(n int) []testTiming {
+	r.mu.Lock()
+	timings := make([]testTiming, 0, len(r.testTimings))
+	for name, d := range r.testTimings {
+		timings = append(timings, testTiming{name: name, duration: d})
+	}
+	r.mu.Unlock()
+
+	sort.Slice(timings, func(i, j int) bool {
+		if timings[i].duration != timings[j].duration {
+			return timings[i].duration > timings[j].duration
+		}
+		return timings[i].name < timings[j].name
+	})
+	if len(timings) > n {
+		timings = timings[:n]
+	}
+	return timings
+}
+
+// printSlowestTests prints the slowest tests, if enabled and more than one
+// test ran.
+func (r *Runner) printSlowestTests() {
+	n := defaultSlowestN
+	if r.config.SlowestN != nil {
+		n = *r.config.SlowestN
+	}
+	if n <= 0 || len(r.testTimings) < 2 {
+		return
+	}
+
+	fmt.Printf("\n=== SLOWEST TESTS\n")
+	for _, t := range r.slowestTests(n) {
+		fmt.Printf("%s (%.2fs)\n", t.name, t.duration.Seconds())
+	}
+}
+
+// checkTestDuration fails a passing test that took longer than
+// MaxTestDuration.
+func (r *Runner) checkTestDuration(test string, duration time.Duration, output io.Writer) error {
+	if r.config.MaxTestDuration <= 0 || duration <= r.config.MaxTestDuration {
+		return nil
+	}
+	err := fmt.Errorf("too slow: took %.2fs (limit %s)", duration.Seconds(), r.config.MaxTestDuration)
+	fmt.Fprintf(output, "\n--- ERROR: Test %v\n", err)
+	r.mu.Lock()
+	r.tooSlowTests = append(r.tooSlowTests, test)
+	r.mu.Unlock()
+	return err
+}
+
+// printTooSlowTests prints the tests that failed for exceeding
+// MaxTestDuration.
+func (r *Runner) printTooSlowTests() {
+	if len(r.tooSlowTests) == 0 {
+		return
+	}
+	fmt.Printf("\n=== TOO SLOW (limit %s)\n", r.config.MaxTestDuration)
+	for _, test := range r.tooSlowTests {
+		fmt.Printf("%s (%.2fs)\n", test, r.testTimings[test].Seconds())
+	}
+}
+
+// runStats are aggregate statistics of a run.
+type runStats struct {
+	passed, completed int
+	// testTime is the sum of the test durations.
+	testTime time.Duration
+	// speedup is testTime over the wall-clock duration of the run.
+	speedup float64
+	// efficiency is speedup as a fraction of the parallelism used.
+	efficiency float64
+}
+
+// runStats computes the aggregate statistics of a run that took
+// suiteDuration.
+func (r *Runner) runStats(suiteDuration time.Duration) runStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := runStats{passed: len(r.passedTests), completed: len(r.passedTests) + len(r.failedTests)}
+	for _, d := range r.testTimings {
+		stats.testTime += d
+	}
+	if suiteDuration > 0 {
+		stats.speedup = float64(stats.testTime) / float64(suiteDuration)
+	}
+	parallelism := r.testParallelism()
+	if r.config.NoParallel || parallelism < 1 {
+		parallelism = 1
+	}
+	parallelism = min(parallelism, max(len(r.testsToRun), 1))
+	stats.efficiency = stats.speedup / float64(parallelism)
+	return stats
+}
+
+// printStats prints the pass rate and parallelism efficiency of the run.
+func (r *Runner) printStats(suiteDuration time.Duration) {
+	stats := r.runStats(suiteDuration)
+	if stats.completed == 0 {
+		return
+	}
+	fmt.Printf("\n=== STATS: %d/%d passed (%.1f%%), %.2fs of tests in %.2fs (%.2fx speedup, %.0f%% parallelism efficiency)\n",
+		stats.passed, stats.completed, 100*float64(stats.passed)/float64(stats.completed),
+		stats.testTime.Seconds(), suiteDuration.Seconds(), stats.speedup, 100*stats.efficiency)
+}
+
+// printTimes prints where the time of the run went: building the image,
+// running the tests, and in total since Setup started, which also includes
+// finding the tests and waiting for dependencies. It is only printed after
+// Setup.
+func (r *Runner) printTimes(suiteDuration time.Duration) {
+	if r.setupStart.IsZero() {
+		return
+	}
+	fmt.Printf("\n=== TIME: build %.2fs, tests %.2fs, total %.2fs\n", r.buildDuration.Seconds(), suiteDuration.Seconds(), time.Since(r.setupStart).Seconds())
+}
+
+// Results are the outcome counts of a test run.
+type Results struct {
+	Passed     int
+	Failed     int
+	Incomplete int
+	// Skipped counts tests excluded by the skip pattern or a
+	// skip-unless-env annotation, tests not started within TimeBudget and,
+	// with ReportSkips, tests that called t.Skip.
+	Skipped int
+	// Duration is the duration of running the tests, and BuildDuration of
+	// building the test image in Setup.
+	Duration      time.Duration
+	BuildDuration time.Duration
+	// Tests are the per-test results, in the order they are summarized.
+	Tests []TestResult
+}
+
+// TestResult is the outcome of a single test. Status is one of PASS, FAIL,
+// SKIP, or STOP for tests that did not complete.
+type TestResult struct {
+	Name     string
+	Status   string
+	Duration time.Duration
+	// Output is the test container's combined output, capped by
+	// MaxOutputBytes.
+	Output string
+	// Stdout and Stderr are the test container's output streams, each
+	// capped like the combined output by MaxOutputBytes. With a TTY, docker
+	// merges stderr into stdout.
+	Stdout string
+	Stderr string
+}
+
+// testStreams are the captured output of a test.
+type testStreams struct {
+	output, stdout, stderr string
+}
+
+// Results returns the results of the last RunTests call.
+func (r *Runner) Results() Results {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	results := Results{
+		Passed:        len(r.passedTests),
+		Failed:        len(r.failedTests),
+		Incomplete:    len(r.incompleteTests),
+		Skipped:       len(r.skippedTests) + len(r.selfSkippedTests) + len(r.budgetSkippedTests) + len(r.envSkippedTests),
+		Duration:      r.suiteDuration,
+		BuildDuration: r.buildDuration,
+	}
+	add := func(tests []string, status string) {
+		for _, test := range tests {
+			streams := r.testStreams[test]
+			results.Tests = append(results.Tests, TestResult{Name: test, Status: status, Duration: r.testTimings[test], Output: streams.output, Stdout: streams.stdout, Stderr: streams.stderr})
+		}
+	}
+	add(r.passedTests, "PASS")
+	add(r.selfSkippedTests, "SKIP")
+	add(r.skippedTests, "SKIP")
+	add(r.budgetSkippedTests, "SKIP")
+	add(r.envSkippedTests, "SKIP")
+	add(r.failedTests, "FAIL")
+	add(r.incompleteTests, "STOP")
+	return results
+}
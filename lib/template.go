@@ -0,0 +1,71 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// builtinSummaryTemplates are the named templates SummaryTemplate accepts in
+// place of a template string.
+var builtinSummaryTemplates = map[string]string{
+	"markdown": `{{if .Failed}}### :x: E2E tests failed{{else}}### :white_check_mark: E2E tests passed{{end}}
+
+{{.Passed}} passed, {{.Failed}} failed, {{.Incomplete}} incomplete, {{.Skipped}} skipped in {{printf "%.2f" .Duration.Seconds}}s
+
+| Test | Status | Duration |
+| --- | --- | --- |
+{{range .Tests}}| {{.Name}} | {{.Status}} | {{printf "%.2f" .Duration.Seconds}}s |
+{{end}}`,
+	"github-actions": `{{range .Tests}}{{if eq .Status "FAIL"}}::error title=E2E test failed::{{.Name}} failed ({{printf "%.2f" .Duration.Seconds}}s)
+{{else if eq .Status "STOP"}}::warning title=E2E test stopped::{{.Name}} did not complete
+{{end}}{{end}}::notice title=E2E summary::{{.Passed}} passed, {{.Failed}} failed, {{.Incomplete}} incomplete, {{.Skipped}} skipped in {{printf "%.2f" .Duration.Seconds}}s
+`,
+}
+
+// parseSummaryTemplate parses s as a text/template for the summary, or
+// returns the built-in template of that name.
+func parseSummaryTemplate(s string) (*template.Template, error) {
+	if builtin, ok := builtinSummaryTemplates[s]; ok {
+		s = builtin
+	}
+	tmpl, err := template.New("summary").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid summary template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// printTemplateSummary renders the summary template with the run's Results.
+func (r *Runner) printTemplateSummary() {
+	fmt.Println()
+	if err := r.summaryTemplate.Execute(os.Stdout, r.Results()); err != nil {
+		fmt.Printf("--- ERROR: Failed to render summary template: %v\n", err)
+	}
+}
+
+// parseRunPattern parses RunPatternTemplate, checking that it renders.
+func parseRunPattern(s string) (*template.Template, error) {
+	tmpl, err := template.New("run-pattern").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run pattern template: %v", err)
+	}
+	if err := tmpl.Execute(&strings.Builder{}, struct{ Name string }{"TestName"}); err != nil {
+		return nil, fmt.Errorf("invalid run pattern template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// runPattern returns the -test.run pattern that runs a test, rendered from
+// RunPatternTemplate or anchored to the test's name by default.
+func (r *Runner) runPattern(name string) string {
+	if r.runPatternTemplate == nil {
+		return fmt.Sprintf("^%s$", name)
+	}
+	var b strings.Builder
+	if err := r.runPatternTemplate.Execute(&b, struct{ Name string }{name}); err != nil {
+		return fmt.Sprintf("^%s$", name)
+	}
+	return b.String()
+}
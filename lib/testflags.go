@@ -0,0 +1,37 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readTestFlagsFile reads the test binary flags in a TestFlagsFile. Each line
+// is split into arguments like a shell would; blank lines and lines starting
+// with # are ignored. Flags selecting which tests run are rejected, as the
+// runner passes -test.run to run one test per container.
+func readTestFlagsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid test flags file: %v", err)
+	}
+	var flags []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words, err := splitShellWords(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid test flags file %s:%d: %v", path, i+1, err)
+		}
+		for _, word := range words {
+			name, _, _ := strings.Cut(strings.TrimLeft(word, "-"), "=")
+			if name == "test.run" || name == "run" {
+				return nil, fmt.Errorf("invalid test flags file %s:%d: %s is set by the runner", path, i+1, word)
+			}
+		}
+		flags = append(flags, words...)
+	}
+	return flags, nil
+}
@@ -0,0 +1,27 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// exportTimings writes the test timings to path as a JSON object mapping test
+// names to durations in seconds, e.g. {"TestExample1": 0.19}. Keys are sorted.
+func (r *Runner) exportTimings(path string) error {
+	r.mu.Lock()
+	timings := make(map[string]float64, len(r.testTimings))
+	for test, d := range r.testTimings {
+		timings[test] = d.Seconds()
+	}
+	r.mu.Unlock()
+
+	data, err := json.MarshalIndent(timings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal timings: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write timings: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package e2e
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWaitForTimeout = 60 * time.Second
+	waitForInterval       = 500 * time.Millisecond
+	waitForContainer      = "container:"
+)
+
+// waitForDependencies polls each configured dependency, and each dependency
+// required by a test to run, until it is ready or the timeout elapses.
+// Dependencies are either host:port TCP endpoints or container:<name>
+// entries, which wait for the container's health check to report healthy.
+func (r *Runner) waitForDependencies() error {
+	deps := slices.Clone(r.config.WaitFor)
+	for _, test := range r.testsToRun {
+		for _, dep := range r.testRequires[r.testName(test)] {
+			if !slices.Contains(deps, dep) {
+				deps = append(deps, dep)
+			}
+		}
+	}
+	return r.waitFor(deps, true)
+}
+
+// waitForTestDependencies waits for the dependencies a test requires, which
+// Setup already waited for, in case one has become unavailable since.
+func (r *Runner) waitForTestDependencies(test string) error {
+	return r.waitFor(r.testRequires[r.testName(test)], false)
+}
+
+// waitFor polls each of deps until it is ready or WaitForTimeout elapses,
+// printing progress if verbose.
+func (r *Runner) waitFor(deps []string, verbose bool) error {
+	timeout := r.config.WaitForTimeout
+	if timeout <= 0 {
+		timeout = defaultWaitForTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for _, dep := range deps {
+		if verbose {
+			fmt.Printf("--- INFO: Waiting for %s...\n", dep)
+		}
+		start := time.Now()
+		for {
+			err := checkDependency(dep)
+			if err == nil {
+				break
+			}
+			if r.config.Verbosity > 2 {
+				fmt.Printf("--- DEBUG: %s not ready: %v\n", dep, err)
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("dependency %s did not become ready within %s: %v", dep, timeout, err)
+			}
+			time.Sleep(waitForInterval)
+		}
+		if verbose {
+			fmt.Printf("--- OK: %s ready (%.2fs)\n", dep, time.Since(start).Seconds())
+		}
+	}
+	return nil
+}
+
+// checkDependency returns nil if the dependency is ready.
+func checkDependency(dep string) error {
+	if name, ok := strings.CutPrefix(dep, waitForContainer); ok {
+		output, err := exec.Command("docker", "inspect", "--format", "{{if .State.Health}}{{.State.Health.Status}}{{else}}{{.State.Status}}{{end}}", name).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+		}
+		switch status := strings.TrimSpace(string(output)); status {
+		case "healthy", "running":
+			return nil
+		default:
+			return fmt.Errorf("container is %s", status)
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", dep, time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func validateWaitFor(deps []string) error {
+	for _, dep := range deps {
+		if name, ok := strings.CutPrefix(dep, waitForContainer); ok {
+			if name == "" {
+				return fmt.Errorf("invalid wait-for entry %q: missing container name", dep)
+			}
+			continue
+		}
+		if _, _, err := net.SplitHostPort(dep); err != nil {
+			return fmt.Errorf("invalid wait-for entry %q: expected host:port or container:<name>", dep)
+		}
+	}
+	return nil
+}
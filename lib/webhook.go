@@ -0,0 +1,90 @@
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// webhookPayload is the JSON body POSTed to WebhookURL after a run.
+type webhookPayload struct {
+	RunID       string   `json:"run_id"`
+	Status      string   `json:"status"`
+	Passed      int      `json:"passed"`
+	Failed      int      `json:"failed"`
+	Incomplete  int      `json:"incomplete"`
+	Skipped     int      `json:"skipped"`
+	Duration    float64  `json:"duration"`
+	FailedTests []string `json:"failed_tests"`
+}
+
+// validateWebhookURL checks that the webhook URL, if set, is an http or
+// https URL.
+func validateWebhookURL(webhookURL string) error {
+	if webhookURL == "" {
+		return nil
+	}
+	if u, err := url.Parse(webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url %q: expected an http or https URL", webhookURL)
+	}
+	return nil
+}
+
+// notifyWebhook POSTs the results of the run to WebhookURL, if set. It is
+// best-effort: a failure is printed but does not fail the run.
+func (r *Runner) notifyWebhook(status string) {
+	if r.config.WebhookURL == "" {
+		return
+	}
+	if err := r.postWebhook(status); err != nil {
+		fmt.Printf("--- INFO: Failed to notify webhook: %v\n", err)
+	} else if r.config.Verbosity > 1 {
+		fmt.Printf("--- DEBUG: Notified webhook of %s run\n", status)
+	}
+}
+
+// postWebhook POSTs the results of the run to WebhookURL, waiting for up to
+// webhookTimeout, and returns an error unless it responds with a 2xx status.
+func (r *Runner) postWebhook(status string) error {
+	results := r.Results()
+	payload := webhookPayload{
+		RunID:       r.runID,
+		Status:      status,
+		Passed:      results.Passed,
+		Failed:      results.Failed,
+		Incomplete:  results.Incomplete,
+		Skipped:     results.Skipped,
+		Duration:    results.Duration.Seconds(),
+		FailedTests: []string{},
+	}
+	for _, test := range results.Tests {
+		if test.Status == "FAIL" {
+			payload.FailedTests = append(payload.FailedTests, test.Name)
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	req, err := http.NewRequest(http.MethodPost, r.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
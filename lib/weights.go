@@ -0,0 +1,47 @@
+package e2e
+
+import "sync"
+
+// weightedSemaphore bounds the total weight of the tests running at once.
+type weightedSemaphore struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	size int
+	used int
+}
+
+func newWeightedSemaphore(size int) *weightedSemaphore {
+	s := &weightedSemaphore{size: max(size, 1)}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// acquire waits until weight fits in the remaining capacity and takes it. A
+// weight larger than the size waits for all capacity, so the test runs alone.
+func (s *weightedSemaphore) acquire(weight int) {
+	weight = min(weight, s.size)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.used+weight > s.size {
+		s.cond.Wait()
+	}
+	s.used += weight
+}
+
+// release frees weight taken by acquire.
+func (s *weightedSemaphore) release(weight int) {
+	weight = min(weight, s.size)
+	s.mu.Lock()
+	s.used -= weight
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// testWeight returns the number of parallelism slots a test takes: its
+// weight annotation if it has one, otherwise 1.
+func (r *Runner) testWeight(test string) int {
+	if weight, ok := r.testWeights[r.testName(test)]; ok {
+		return weight
+	}
+	return 1
+}
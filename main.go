@@ -1,12 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 
 	e2e "github.com/snormore/go-e2e/lib"
 	"gopkg.in/yaml.v3"
@@ -19,29 +23,97 @@ var (
 func main() {
 	if err := run(); err != nil {
 		fmt.Printf("--- ERROR: %v\n", err)
+		if hint := errorHint(err); hint != "" {
+			fmt.Printf("--- HINT: %s\n", hint)
+		}
 		os.Exit(1)
 	}
 }
 
+// errorHint returns a remediation hint for known setup errors.
+func errorHint(err error) string {
+	switch {
+	case errors.Is(err, e2e.ErrDaemonUnavailable):
+		return "is Docker installed and running?"
+	case errors.Is(err, e2e.ErrDockerfileNotFound):
+		return "check the dockerfile path in your config file; it is relative to the config file"
+	case errors.Is(err, e2e.ErrBuildFailed):
+		return "run with -v to see the full docker build output"
+	case errors.Is(err, e2e.ErrNoTests):
+		return "check the -run and -skip patterns and build tags; run with -vv to see why tests were excluded"
+	default:
+		return ""
+	}
+}
+
 func run() error {
+	preprocessArgsForVerbosity()
+
+	if len(os.Args) > 1 && os.Args[1] == "prune" {
+		return runPrune(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		return runValidate(os.Args[2:])
+	}
+
 	var configFile string
 	var verbosity int
+	var failFast bool
 	var noFastFail bool
 	var noParallel bool
+	var noColor bool
+	var compact bool
 	var parallelism int
 	var testPattern string
+	var skipPattern string
+	var changedSince string
+	var rerunFailed bool
+	var sinceLastPass bool
+	var reportSkips bool
+	var strict bool
+	var full bool
+	var order string
+	var exitCodePolicy string
+	var slowestN int
+	var seed int64
+	var shuffle bool
+	var suiteParallelism int
+	var watch bool
+	var printConfig bool
+	var list bool
+	var maxContainers int
+	var runID string
 
 	config := e2e.RunnerConfig{}
 
-	preprocessArgsForVerbosity()
-
 	flag.StringVar(&configFile, "f", "e2e.yaml", "Config filename to search for recursively (default: e2e.yaml)")
 	flag.IntVar(&verbosity, "verbose", 0, "Verbosity level (default: 0)")
-	flag.BoolVar(&noFastFail, "no-fast-fail", false, "Run all tests even if one fails (default: false)")
+	flag.BoolVar(&failFast, "fail-fast", true, "Stop running tests after the first failure (default: true)")
+	flag.BoolVar(&noFastFail, "no-fast-fail", false, "Deprecated: use -fail-fast=false")
 	flag.BoolVar(&noParallel, "no-parallel", false, "Run tests sequentially instead of in parallel (default: false)")
+	flag.BoolVar(&noColor, "no-color", false, "Disable colorized output (default: false)")
+	flag.BoolVar(&compact, "compact", false, "Print a single line per test when it completes (default: false)")
 	flag.IntVar(&parallelism, "parallelism", defaultParallelism, "Number of tests to run in parallel (default: number of CPUs)")
 	flag.IntVar(&parallelism, "p", defaultParallelism, "Number of tests to run in parallel (default: number of CPUs)")
 	flag.StringVar(&testPattern, "run", "", "Run only tests matching the pattern (default: all tests)")
+	flag.StringVar(&skipPattern, "skip", "", "Skip tests matching the pattern (default: none)")
+	flag.StringVar(&changedSince, "changed-since", "", "Run only tests in packages changed since the git ref (default: all tests)")
+	flag.BoolVar(&rerunFailed, "failed", false, "Run only the tests that failed in the previous run (default: false)")
+	flag.BoolVar(&sinceLastPass, "since-last-pass", false, "Skip tests that passed in the previous run and whose package sources are unchanged (default: false)")
+	flag.BoolVar(&full, "full", false, "Run all tests, overriding since-last-pass (default: false)")
+	flag.BoolVar(&reportSkips, "report-skips", false, "Report tests that call t.Skip as SKIP instead of PASS (default: false)")
+	flag.BoolVar(&strict, "strict", false, "Fail unless every test selected to run passed, including skipped and incomplete tests (default: false)")
+	flag.StringVar(&order, "order", "source", "Order to run tests in: source, alpha or random (default: source)")
+	flag.Int64Var(&seed, "seed", 0, "Seed for random test order and -shuffle (default: time-based)")
+	flag.BoolVar(&shuffle, "shuffle", false, "Pass -test.shuffle with the seed to each test binary (default: false)")
+	flag.StringVar(&exitCodePolicy, "exit-code-policy", "any-failure", "When failed tests fail the run: any-failure, ignore-incomplete or threshold:N (default: any-failure)")
+	flag.StringVar(&runID, "run-id", "", "ID of the run set on every test container, e.g. a CI job ID (default: random)")
+	flag.IntVar(&slowestN, "slowest", 10, "Number of slowest tests to list in the summary, 0 to disable (default: 10)")
+	flag.IntVar(&maxContainers, "max-containers", 0, "Maximum number of test containers running at once across all suites, 0 for no limit (default: 0)")
+	flag.IntVar(&suiteParallelism, "suite-parallelism", 1, "Number of config files to set up and build images for in parallel (default: 1)")
+	flag.BoolVar(&printConfig, "print-config", false, "Print the effective config of each config file, with flags and defaults applied, and exit (default: false)")
+	flag.BoolVar(&list, "list", false, "List the tests that would run in each config file, without building or running anything, and exit (default: false)")
+	flag.BoolVar(&watch, "watch", false, "Re-run tests when files change, until interrupted (default: false)")
 	help := flag.Bool("help", false, "Show help")
 
 	flag.Parse()
@@ -55,9 +127,33 @@ func run() error {
 	// Set the flags-only config values
 	config.Verbosity = verbosity
 	config.NoFastFail = noFastFail
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "fail-fast":
+			config.FailFast = &failFast
+		case "slowest":
+			config.SlowestN = &slowestN
+		}
+	})
 	config.NoParallel = noParallel
+	config.NoColor = noColor
+	config.Compact = compact
 	config.Parallelism = parallelism
 	config.TestPattern = testPattern
+	config.SkipPattern = skipPattern
+	config.ChangedSince = changedSince
+	config.RerunFailed = rerunFailed
+	config.SinceLastPass = sinceLastPass
+	config.ReportSkips = reportSkips
+	config.StrictMode = strict
+	config.Order = order
+	config.Seed = seed
+	config.Shuffle = shuffle
+	config.ExitCodePolicy = exitCodePolicy
+	config.RunID = runID
+	if maxContainers > 0 {
+		config.ContainerLimiter = e2e.NewContainerLimiter(maxContainers)
+	}
 
 	// Find all e2e.yaml files recursively
 	if verbosity > 2 {
@@ -74,53 +170,353 @@ func run() error {
 		return fmt.Errorf("no e2e.yaml files found")
 	}
 
-	// Run each config file
-	for _, configFile := range configFiles {
-		fmt.Printf("\n=== Running tests from %s ===\n", configFile)
+	if printConfig {
+		return printConfigs(config, configFiles, full)
+	}
+	if list {
+		return listTests(config, configFiles)
+	}
+	if watch {
+		return watchSuites(config, configFiles, full, suiteParallelism)
+	}
+	return runSuites(config, configFiles, full, suiteParallelism)
+}
 
-		// Get the absolute path of the config file
-		absConfigFile, err := filepath.Abs(configFile)
+// runSuites sets up and runs the suite of each config file, on top of the
+// flag values in config.
+func runSuites(config e2e.RunnerConfig, configFiles []string, full bool, suiteParallelism int) error {
+	// Load each config file
+	suites := make([]suite, 0, len(configFiles))
+	for _, configFile := range configFiles {
+		suiteConfig, err := loadConfig(config, configFile, false)
 		if err != nil {
-			return fmt.Errorf("failed to get absolute path of config file: %v", err)
+			return err
 		}
-
-		// Read the config file
-		data, err := os.ReadFile(absConfigFile)
+		if full {
+			suiteConfig.SinceLastPass = false
+		}
+		runner, err := e2e.NewRunner(suiteConfig)
 		if err != nil {
-			return fmt.Errorf("failed to read config file: %v", err)
+			return err
 		}
+		suites = append(suites, suite{configFile: configFile, config: suiteConfig, runner: runner})
+	}
+	defer func() {
+		for _, s := range suites {
+			s.runner.Cleanup()
+		}
+	}()
 
-		// Parse the config file
-		if err := yaml.Unmarshal(data, &config); err != nil {
-			return fmt.Errorf("failed to parse config file: %v", err)
+	// Build the images for all suites up front when building in parallel.
+	if suiteParallelism > 1 && len(suites) > 1 {
+		if err := setupSuites(suites, suiteParallelism); err != nil {
+			return err
 		}
+	}
 
-		configDir := filepath.Dir(absConfigFile)
-		if config.Dockerfile != "" {
-			configFileDir := filepath.Dir(absConfigFile)
-			config.Dockerfile = filepath.Join(configFileDir, config.Dockerfile)
+	// Run each suite
+	var total e2e.Results
+	var failedSuites []string
+	var testsErr error
+	ran := 0
+	for _, s := range suites {
+		fmt.Printf("\n=== Running tests from %s ===\n", s.configFile)
+
+		if suiteParallelism <= 1 || len(suites) == 1 {
+			if err := s.runner.Setup(); err != nil {
+				return err
+			}
 		}
 
-		// The test dir for this run is the directory of the config file.
-		config.TestDir = configDir
+		err := s.runner.RunTests()
+		ran++
+		results := s.runner.Results()
+		total.Passed += results.Passed
+		total.Failed += results.Failed
+		total.Incomplete += results.Incomplete
+		total.Skipped += results.Skipped
+		total.Duration += results.Duration
+		total.BuildDuration += results.BuildDuration
+		if err != nil {
+			var failed *e2e.TestsFailedError
+			var strict *e2e.StrictModeError
+			if !errors.As(err, &strict) {
+				if !errors.As(err, &failed) {
+					return err
+				}
+				policy, perr := e2e.ParseExitCodePolicy(s.config.ExitCodePolicy)
+				if perr != nil {
+					return perr
+				}
+				if !policy.Fails(failed) {
+					fmt.Printf("--- INFO: Ignoring test failures per exit code policy %s\n", policy)
+					continue
+				}
+			}
+			failedSuites = append(failedSuites, s.configFile)
+			testsErr = err
+			if s.runner.FailFast() {
+				break
+			}
+		}
+	}
+
+	if len(suites) > 1 {
+		printTotal(total, ran, len(suites), failedSuites)
+	}
+	if len(failedSuites) > 1 {
+		return fmt.Errorf("tests failed in %d suites: %s", len(failedSuites), strings.Join(failedSuites, ", "))
+	}
+	return testsErr
+}
 
-		runner, err := e2e.NewRunner(config)
+// printConfigs prints the effective config of each config file on top of the
+// flag values in config, as YAML with secrets redacted.
+func printConfigs(config e2e.RunnerConfig, configFiles []string, full bool) error {
+	for i, configFile := range configFiles {
+		suiteConfig, err := loadConfig(config, configFile, false)
 		if err != nil {
 			return err
 		}
-		if err := runner.Setup(); err != nil {
+		if full {
+			suiteConfig.SinceLastPass = false
+		}
+		runner, err := e2e.NewRunner(suiteConfig)
+		if err != nil {
+			return fmt.Errorf("%s: %v", configFile, err)
+		}
+		data, err := runner.ConfigYAML()
+		if err != nil {
 			return err
 		}
-		defer runner.Cleanup()
+		if i > 0 {
+			fmt.Println("---")
+		}
+		fmt.Printf("# %s\n%s", configFile, data)
+	}
+	return nil
+}
 
-		if err := runner.RunTests(); err != nil {
+// listTests prints the tests that would run in each config file, with the
+// file they are in.
+func listTests(config e2e.RunnerConfig, configFiles []string) error {
+	for _, configFile := range configFiles {
+		suiteConfig, err := loadConfig(config, configFile, false)
+		if err != nil {
 			return err
 		}
+		runner, err := e2e.NewRunner(suiteConfig)
+		if err != nil {
+			return fmt.Errorf("%s: %v", configFile, err)
+		}
+		tests, err := runner.DiscoverTests()
+		if err != nil {
+			return fmt.Errorf("%s: %v", configFile, err)
+		}
+		fmt.Printf("=== %s\n", configFile)
+		for _, test := range tests {
+			if test.Excluded == "" {
+				fmt.Printf("%s\t%s\n", test.Name, test.File)
+			} else if suiteConfig.Verbosity > 0 {
+				fmt.Printf("%s\t%s\t(excluded: %s)\n", test.Name, test.File, test.Excluded)
+			}
+		}
+	}
+	return nil
+}
+
+// printTotal prints the results aggregated across the suites that ran.
+func printTotal(total e2e.Results, ran, suites int, failedSuites []string) {
+	status := "PASS"
+	if len(failedSuites) > 0 {
+		status = "FAIL"
 	}
+	fmt.Printf("\n=== TOTAL: %s (%d of %d suites, %.2fs)\n", status, ran, suites, total.Duration.Seconds())
+	fmt.Printf("%d passed, %d failed, %d incomplete, %d skipped\n", total.Passed, total.Failed, total.Incomplete, total.Skipped)
+	fmt.Printf("build %.2fs, tests %.2fs\n", total.BuildDuration.Seconds(), total.Duration.Seconds())
+	for _, configFile := range failedSuites {
+		fmt.Printf("FAIL: %s\n", configFile)
+	}
+}
+
+type suite struct {
+	configFile string
+	config     e2e.RunnerConfig
+	runner     *e2e.Runner
+}
+
+// loadConfig reads a config file on top of the flag values in base, resolving
+// paths in it relative to the config file's directory. With strict, unknown
+// keys in the config file are an error.
+func loadConfig(base e2e.RunnerConfig, configFile string, strict bool) (e2e.RunnerConfig, error) {
+	config := base
 
+	// Copy the values of pointer fields, which the decoder would otherwise
+	// write through into base and so into the configs loaded after this one.
+	config.FailFast = clonePtr(base.FailFast)
+	config.TTY = clonePtr(base.TTY)
+	config.FailureOutputLines = clonePtr(base.FailureOutputLines)
+	config.SlowestN = clonePtr(base.SlowestN)
+
+	// Get the absolute path of the config file
+	absConfigFile, err := filepath.Abs(configFile)
+	if err != nil {
+		return config, fmt.Errorf("failed to get absolute path of config file: %v", err)
+	}
+
+	// Read the config file
+	data, err := os.ReadFile(absConfigFile)
+	if err != nil {
+		return config, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	// Parse the config file
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(strict)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return config, fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	configDir := filepath.Dir(absConfigFile)
+	if config.Dockerfile != "" {
+		config.Dockerfile = filepath.Join(configDir, config.Dockerfile)
+	}
+	for i, group := range config.DockerfileGroups {
+		if group.Dockerfile != "" {
+			config.DockerfileGroups[i].Dockerfile = filepath.Join(configDir, group.Dockerfile)
+		}
+	}
+
+	// Resolve relative data volume host paths against the config file directory.
+	for i, vol := range config.DataVolumes {
+		if host, rest, ok := strings.Cut(vol, ":"); ok && !filepath.IsAbs(host) {
+			config.DataVolumes[i] = filepath.Join(configDir, host) + ":" + rest
+		}
+	}
+
+	// Resolve relative build secret paths against the config file directory.
+	for id, path := range config.BuildSecrets {
+		if !filepath.IsAbs(path) {
+			config.BuildSecrets[id] = filepath.Join(configDir, path)
+		}
+	}
+
+	if config.TimingsExportPath != "" && !filepath.IsAbs(config.TimingsExportPath) {
+		config.TimingsExportPath = filepath.Join(configDir, config.TimingsExportPath)
+	}
+	if config.BundlePath != "" && !filepath.IsAbs(config.BundlePath) {
+		config.BundlePath = filepath.Join(configDir, config.BundlePath)
+	}
+	if config.TmpDir != "" && !filepath.IsAbs(config.TmpDir) {
+		config.TmpDir = filepath.Join(configDir, config.TmpDir)
+	}
+	if config.GoModDir != "" && !filepath.IsAbs(config.GoModDir) {
+		config.GoModDir = filepath.Join(configDir, config.GoModDir)
+	}
+	if config.TestFlagsFile != "" && !filepath.IsAbs(config.TestFlagsFile) {
+		config.TestFlagsFile = filepath.Join(configDir, config.TestFlagsFile)
+	}
+
+	// The test dir for this run is the directory of the config file.
+	config.TestDir = configDir
+
+	return config, nil
+}
+
+// clonePtr returns a pointer to a copy of *p, or nil if p is nil.
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// setupSuites sets up the suites concurrently, at most parallelism at a time,
+// and returns the first error encountered.
+func setupSuites(suites []suite, parallelism int) error {
+	fmt.Printf("\n--- INFO: Setting up %d suites in parallel (max %d)...\n", len(suites), parallelism)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(suites))
+	sem := make(chan struct{}, parallelism)
+	for i, s := range suites {
+		wg.Add(1)
+		go func(i int, s suite) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := s.runner.Setup(); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", s.configFile, err)
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func runPrune(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "List what would be removed without removing anything (default: false)")
+	verbosity := fs.Int("verbose", 0, "Verbosity level (default: 0)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return e2e.Prune(*dryRun, *verbosity)
+}
+
+// runValidate checks that config files parse, contain only known keys, and
+// reference existing files, without building or running anything.
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configFile := fs.String("f", "e2e.yaml", "Config filename to search for recursively, if no files are given (default: e2e.yaml)")
+	verbosity := fs.Int("verbose", 0, "Verbosity level (default: 0)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	configFiles := fs.Args()
+	if len(configFiles) == 0 {
+		var err error
+		configFiles, err = e2e.NewFileWalker(*configFile, *verbosity, ".").FindConfigFiles()
+		if err != nil {
+			return fmt.Errorf("failed to find e2e config files: %v", err)
+		}
+		if len(configFiles) == 0 {
+			return fmt.Errorf("no %s files found", *configFile)
+		}
+	}
+
+	invalid := 0
+	for _, configFile := range configFiles {
+		if err := validateConfig(configFile); err != nil {
+			fmt.Printf("--- FAIL: %s: %v\n", configFile, err)
+			invalid++
+			continue
+		}
+		fmt.Printf("--- OK: %s\n", configFile)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d config files are invalid", invalid, len(configFiles))
+	}
 	return nil
 }
 
+// validateConfig strictly loads a config file and checks the files it
+// references exist.
+func validateConfig(configFile string) error {
+	config, err := loadConfig(e2e.RunnerConfig{}, configFile, true)
+	if err != nil {
+		return err
+	}
+	runner, err := e2e.NewRunner(config)
+	if err != nil {
+		return err
+	}
+	return runner.Validate()
+}
+
 func preprocessArgsForVerbosity() {
 	newArgs := []string{os.Args[0]}
 	for _, arg := range os.Args[1:] {
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	e2e "github.com/snormore/go-e2e/lib"
+)
+
+func TestLoadConfigDoesNotShareFlagValues(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.yaml")
+	b := filepath.Join(dir, "b.yaml")
+	if err := os.WriteFile(a, []byte("fail-fast: false\nslowest-n: 3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("dockerfile: Dockerfile\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	failFast, slowestN := true, 10
+	base := e2e.RunnerConfig{FailFast: &failFast, SlowestN: &slowestN}
+	configA, err := loadConfig(base, a, false)
+	if err != nil {
+		t.Fatalf("failed to load %s: %v", a, err)
+	}
+	configB, err := loadConfig(base, b, false)
+	if err != nil {
+		t.Fatalf("failed to load %s: %v", b, err)
+	}
+	if *configA.FailFast || *configA.SlowestN != 3 {
+		t.Errorf("expected fail-fast false and slowest-n 3 from %s, got %v and %d", a, *configA.FailFast, *configA.SlowestN)
+	}
+	if !*configB.FailFast || *configB.SlowestN != 10 {
+		t.Errorf("expected the flag values in %s, got fail-fast %v and slowest-n %d", b, *configB.FailFast, *configB.SlowestN)
+	}
+	if !failFast || slowestN != 10 {
+		t.Errorf("expected the flag values to be unchanged, got fail-fast %v and slowest-n %d", failFast, slowestN)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":          "module example.com/a\n\ngo 1.24\n",
+		"Dockerfile":      "FROM scratch\n",
+		"Dockerfile.slow": "FROM scratch\n",
+		"secret.txt":      "secret\n",
+		"flags.txt":       "-test.count=1\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	valid := "dockerfile: Dockerfile\n" +
+		"dockerfile-groups:\n- dockerfile: Dockerfile.slow\n  test-pattern: Slow\n" +
+		"build-secrets:\n  token: secret.txt\n" +
+		"test-flags-file: flags.txt\n" +
+		"bundle-path: run.tar.gz\n" +
+		"timings-export-path: timings.json\n"
+	for _, tt := range []struct {
+		config  string
+		invalid string
+	}{
+		{valid, ""},
+		{"dockerfile: Dockerfile.missing\n", "Dockerfile.missing"},
+		{"dockerfile: Dockerfile\ndockerfile-groups:\n- dockerfile: Dockerfile.missing\n  test-pattern: Slow\n", "Dockerfile.missing"},
+		{"dockerfile: Dockerfile\nbuild-secrets:\n  token: missing.txt\n", "token"},
+		{"dockerfile: Dockerfile\ntest-flags-file: missing.txt\n", "missing.txt"},
+		{"dockerfile: Dockerfile\ndata-volumes:\n- missing:/data\n", "missing"},
+		{"dockerfile: Dockerfile\nbundle-path: missing/run.tar.gz\n", "bundle-path"},
+		{"dockerfile: Dockerfile\ntimings-export-path: missing/timings.json\n", "timings-export-path"},
+		{"dockerfile: Dockerfile\nwait-for:\n- localhost\n", "localhost"},
+	} {
+		configFile := filepath.Join(dir, "e2e.yaml")
+		if err := os.WriteFile(configFile, []byte(tt.config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		err := validateConfig(configFile)
+		if tt.invalid == "" && err != nil {
+			t.Errorf("unexpected error for %q: %v", tt.config, err)
+		} else if tt.invalid != "" && (err == nil || !strings.Contains(err.Error(), tt.invalid)) {
+			t.Errorf("expected error mentioning %q for %q, got %v", tt.invalid, tt.config, err)
+		}
+	}
+
+	// Asset directories mounted into test containers must be directories.
+	if err := os.WriteFile(filepath.Join(dir, "e2e.yaml"), []byte("dockerfile: Dockerfile\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "testdata"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateConfig(filepath.Join(dir, "e2e.yaml")); err == nil || !strings.Contains(err.Error(), "testdata") {
+		t.Errorf("expected error for a testdata file, got %v", err)
+	}
+}
+
+func TestWatchDirs(t *testing.T) {
+	root := t.TempDir()
+	suite := filepath.Join(root, "e2e")
+	if err := os.Mkdir(suite, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	other := t.TempDir()
+	for path, content := range map[string]string{
+		filepath.Join(root, "go.mod"):      "module example.com/a\n\ngo 1.24\n",
+		filepath.Join(suite, "e2e.yaml"):   "dockerfile: Dockerfile\n",
+		filepath.Join(suite, "Dockerfile"): "FROM scratch\n",
+		// A suite whose build context can't be resolved is still watched.
+		filepath.Join(other, "e2e.yaml"): "dockerfile: Dockerfile\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirs := watchDirs(e2e.RunnerConfig{}, []string{filepath.Join(suite, "e2e.yaml"), filepath.Join(other, "e2e.yaml")})
+	want := []string{root, other}
+	sort.Strings(want)
+	if !slices.Equal(dirs, want) {
+		t.Errorf("expected to watch %v, got %v", want, dirs)
+	}
+}
+
+func TestWatchSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	watched := filepath.Join(dir, "watched")
+	unwatched := filepath.Join(dir, "unwatched")
+	for _, d := range []string{watched, unwatched, filepath.Join(watched, ".hidden")} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(path), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(watched, "a.go"))
+
+	snapshot, err := watchSnapshot([]string{watched})
+	if err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	unchanged := func() bool {
+		t.Helper()
+		current, err := watchSnapshot([]string{watched})
+		if err != nil {
+			t.Fatalf("failed to snapshot: %v", err)
+		}
+		return snapshotsEqual(current, snapshot)
+	}
+
+	// Files outside the watched directories and in hidden directories are
+	// not watched.
+	write(filepath.Join(unwatched, "b.go"))
+	write(filepath.Join(watched, ".hidden", "c"))
+	if !unchanged() {
+		t.Errorf("expected changes outside the watched files to be ignored")
+	}
+
+	// Changed, added and deleted files are detected.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(watched, "a.go"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if unchanged() {
+		t.Errorf("expected a changed file to be detected")
+	}
+	if snapshot, err = watchSnapshot([]string{watched}); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	write(filepath.Join(watched, "d.go"))
+	if unchanged() {
+		t.Errorf("expected an added file to be detected")
+	}
+	if snapshot, err = watchSnapshot([]string{watched}); err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+	if err := os.Remove(filepath.Join(watched, "d.go")); err != nil {
+		t.Fatal(err)
+	}
+	if unchanged() {
+		t.Errorf("expected a deleted file to be detected")
+	}
+}
+
+func TestWaitForChangesDebounce(t *testing.T) {
+	interval, debounce := watchInterval, watchDebounce
+	watchInterval, watchDebounce = 10*time.Millisecond, 200*time.Millisecond
+	t.Cleanup(func() { watchInterval, watchDebounce = interval, debounce })
+
+	dir := t.TempDir()
+	snapshot, err := watchSnapshot([]string{dir})
+	if err != nil {
+		t.Fatalf("failed to snapshot: %v", err)
+	}
+
+	// A burst of saves, each within the debounce of the last, triggers a
+	// single run once the last save is debounced.
+	saved := make(chan time.Time, 1)
+	go func() {
+		for i := 0; i < 5; i++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.go", i)), nil, 0o644); err != nil {
+				t.Error(err)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		saved <- time.Now()
+	}()
+	if err := waitForChanges([]string{dir}, snapshot); err != nil {
+		t.Fatalf("failed to wait for changes: %v", err)
+	}
+	select {
+	case <-saved:
+	default:
+		t.Errorf("expected waiting to continue until the burst of saves ended")
+	}
+}
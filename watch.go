@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	e2e "github.com/snormore/go-e2e/lib"
+)
+
+// watchInterval is how often the watched files are checked, and
+// watchDebounce how long they must be unchanged before a re-run, so that a
+// burst of saves triggers a single run. They are variables for tests.
+var (
+	watchInterval = time.Second
+	watchDebounce = 500 * time.Millisecond
+)
+
+// watchSuites runs the suites, then re-runs them whenever files in their
+// test directories or build contexts change, until interrupted. Run errors
+// are reported but don't stop watching.
+func watchSuites(config e2e.RunnerConfig, configFiles []string, full bool, suiteParallelism int) error {
+	dirs := watchDirs(config, configFiles)
+	for {
+		if err := runSuites(config, configFiles, full, suiteParallelism); err != nil {
+			fmt.Printf("--- ERROR: %v\n", err)
+		}
+
+		// Snapshot after the run so that files it writes, like bundles and
+		// exported timings, don't trigger another run.
+		snapshot, err := watchSnapshot(dirs)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\n=== WATCH: Waiting for changes in %s (Ctrl-C to stop)\n", strings.Join(dirs, ", "))
+		if err := waitForChanges(dirs, snapshot); err != nil {
+			return err
+		}
+	}
+}
+
+// watchDirs returns the directories to watch for the suites: the test
+// directory of each config file and, so that changes to the code under test
+// are seen too, the build context of its image if it can be resolved.
+// Directories inside another watched directory are left out.
+func watchDirs(config e2e.RunnerConfig, configFiles []string) []string {
+	var dirs []string
+	for _, configFile := range configFiles {
+		suiteConfig, err := loadConfig(config, configFile, false)
+		if err != nil {
+			continue
+		}
+		dirs = append(dirs, suiteConfig.TestDir)
+		if runner, err := e2e.NewRunner(suiteConfig); err == nil && runner.Validate() == nil {
+			dirs = append(dirs, runner.BuildDir())
+		}
+	}
+	sort.Strings(dirs)
+
+	var result []string
+	for _, dir := range dirs {
+		if n := len(result); n > 0 && (dir == result[n-1] || strings.HasPrefix(dir, result[n-1]+string(filepath.Separator))) {
+			continue
+		}
+		result = append(result, dir)
+	}
+	return result
+}
+
+// waitForChanges polls dirs until their files differ from snapshot and have
+// then been unchanged for watchDebounce.
+func waitForChanges(dirs []string, snapshot map[string]time.Time) error {
+	for {
+		time.Sleep(watchInterval)
+		current, err := watchSnapshot(dirs)
+		if err != nil {
+			return err
+		}
+		if snapshotsEqual(current, snapshot) {
+			continue
+		}
+		for {
+			time.Sleep(watchDebounce)
+			next, err := watchSnapshot(dirs)
+			if err != nil {
+				return err
+			}
+			if snapshotsEqual(next, current) {
+				return nil
+			}
+			current = next
+		}
+	}
+}
+
+// watchSnapshot returns the modification times of the files in dirs, by
+// path. In a git repository, files ignored by git are left out.
+func watchSnapshot(dirs []string) (map[string]time.Time, error) {
+	snapshot := make(map[string]time.Time)
+	for _, dir := range dirs {
+		files, err := watchedFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			path := filepath.Join(dir, file)
+			info, err := os.Stat(path)
+			if err != nil {
+				// Deleted files are missing from the snapshot.
+				continue
+			}
+			snapshot[path] = info.ModTime()
+		}
+	}
+	return snapshot, nil
+}
+
+// watchedFiles lists the files to watch in dir: the files git doesn't ignore,
+// or outside a git repository, all files not in hidden directories.
+func watchedFiles(dir string) ([]string, error) {
+	cmd := exec.Command("git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = dir
+	if output, err := cmd.Output(); err == nil {
+		return strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00"), nil
+	}
+
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files to watch: %v", err)
+	}
+	return files, nil
+}
+
+func snapshotsEqual(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, modTime := range a {
+		if other, ok := b[file]; !ok || !other.Equal(modTime) {
+			return false
+		}
+	}
+	return true
+}